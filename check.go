@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkFiles compares the files against what's on disk, printing a diff of
+// every file that's out of date, and reports any stale files. The provenance
+// in the headers of the files doesn't count, see withoutProvenance. It returns
+// whether everything is up to date.
+//
+// With several packages, it also points out the generated files it couldn't
+// check, since their types belong to go:generate directives of their own.
+func checkFiles(files []*pendingFile, stale, untouched []string) bool {
+	ok := true
+
+	for _, file := range files {
+		current, err := ioutil.ReadFile(file.Name)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Couldn't read %s: %v\n", file.Name, err)
+			os.Exit(1)
+		}
+
+		if bytes.Equal(withoutProvenance(current), withoutProvenance(file.Content)) {
+			continue
+		}
+
+		ok = false
+		name := filepath.ToSlash(relPath(file.Name))
+		if os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "%s is missing\n", name)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s is out of date\n", name)
+		}
+		fmt.Print(unifiedDiff("a/"+name, "b/"+name, current, file.Content))
+	}
+
+	for _, name := range stale {
+		ok = false
+		fmt.Fprintf(os.Stderr, "%s is stale\n", name)
+	}
+
+	if multiPackage {
+		for _, name := range untouched {
+			fmt.Fprintf(os.Stderr, "%s wasn't checked, since it's from a go:generate directive of its own\n", name)
+		}
+	}
+
+	return ok
+}
+
+// staleFiles returns the files of the package we've generated in the past that
+// this run doesn't produce, but should have if they were still needed, i.e.
+// ones for types that are gone, or for types we're now generating elsewhere.
+// The rest of the files we've generated in the past are returned as untouched.
+//
+// Types picked by markers, the config file, -all or -type-regexp are all the
+// types of the package that should have files, so the files of any other types
+// are stale too, e.g. after removing a marker. That is unless they belong to a
+// go:generate directive of their own, i.e. were generated with -type or follow
+// such a directive. With -type, or a type inferred from the directive we're
+// run from, files of other types are always left alone, since they're likely
+// generated by another directive in the package.
+//
+// Every Go file in the package's directory is looked at, not just the ones the
+// package is built from, since a stale file might have been left out of the
+// build by its name or build constraints.
+func staleFiles(files []*pendingFile) (stale, untouched []string) {
+	produced := map[string]bool{}
+	for _, file := range files {
+		produced[absPath(file.Name)] = true
+	}
+
+	names, err := filepath.Glob(filepath.Join(pkgDir(), "*.go"))
+	if err != nil {
+		panic(err)
+	}
+
+	complete := *typeNames == "" && inferType() == ""
+	directed := directedTypes()
+
+	for _, name := range names {
+		if produced[absPath(name)] || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+
+		file, err := parser.ParseFile(token.NewFileSet(), name, nil, parser.ParseComments)
+		if err != nil || !isGenerated(file) {
+			continue
+		}
+
+		isStale := false
+		for _, t := range generatedTypes(file) {
+			_, requested := targets[t]
+			unwanted := complete && !requested && !directed[t] && !generatedForTypes(file)
+			obj, _ := pkg.Types.Scope().Lookup(t).(*types.TypeName)
+			if requested || unwanted || obj == nil || !isStruct(obj.Type()) {
+				isStale = true
+				break
+			}
+		}
+
+		if isStale {
+			stale = append(stale, relPath(name))
+		} else {
+			untouched = append(untouched, relPath(name))
+		}
+	}
+
+	return stale, untouched
+}
+
+// pruneFiles deletes the stale files, or just reports them unless -prune is
+// set.
+func pruneFiles(stale []string) {
+	for _, name := range stale {
+		if !*prune {
+			fmt.Fprintf(os.Stderr, "%s is stale, use -prune to delete it\n", name)
+			continue
+		}
+
+		if err := os.Remove(name); err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't delete %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Deleted stale %s\n", name)
+	}
+}
+
+// generatedTypes returns the types a generated file has options for, going by
+// the option types it declares, e.g. `type Option func(*Animal)`.
+func generatedTypes(file *ast.File) []string {
+	out := []string{}
+
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			fn, ok := ts.Type.(*ast.FuncType)
+			if !ok || !strings.HasSuffix(ts.Name.Name, "Option") || fn.Params.NumFields() != 1 || fn.Results != nil {
+				continue
+			}
+
+			if star, ok := fn.Params.List[0].Type.(*ast.StarExpr); ok {
+				if ident, ok := star.X.(*ast.Ident); ok {
+					out = append(out, ident.Name)
+				}
+			}
+		}
+	}
+
+	return out
+}
+
+func isStruct(typ types.Type) bool {
+	_, ok := typ.Underlying().(*types.Struct)
+	return ok
+}
+
+func absPath(name string) string {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+	return abs
+}
+
+// relPath makes the name relative to the working directory, if it can.
+func relPath(name string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return name
+	}
+	rel, err := filepath.Rel(wd, name)
+	if err != nil {
+		return name
+	}
+	return rel
+}
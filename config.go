@@ -0,0 +1,375 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// defaultOutput is the default -output template.
+const defaultOutput = "zz_generated.{{lower .Type}}_funcop.go"
+
+// typeConfig is how to generate the options of a type, from the flags, the
+// config file, and any marker on the type, e.g.
+// `//funcopgen:generate prefix=With factory`.
+type typeConfig struct {
+	Prefix       string
+	Factory      bool
+	Unexported   bool
+	UniqueOption bool
+	Reset        bool
+	Defaults     bool
+	JSON         bool
+	Flags        bool
+	FromMap      bool
+	FromStruct   bool
+	Schema       bool
+	Output       string
+
+	// outputTemplate is the parsed Output template
+	outputTemplate *template.Template
+}
+
+// register declares flags for the config on the flag set, defaulting to
+// whatever's already in the config.
+func (c *typeConfig) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.Prefix, "prefix", c.Prefix, "Prefix to attach to functional options, e.g. WithColor, WithName, etc.")
+	fs.BoolVar(&c.Factory, "factory", c.Factory, "If present, add a factory function for your type, e.g. NewAnimal(opt ...Option)")
+	fs.BoolVar(&c.Unexported, "unexported", c.Unexported, "If present, functional options are also generated for unexported fields.")
+	fs.BoolVar(&c.UniqueOption, "unique-option", c.UniqueOption,
+		"If present, prepends the type to the Option type, e.g. AnimalOption.\n"+
+			"Handy if generating for several structs within the same package.",
+	)
+	fs.BoolVar(&c.Reset, "reset", c.Reset, "If present, add options restoring fields to their defaults, e.g. ResetColor()")
+	fs.BoolVar(&c.Defaults, "defaults", c.Defaults, "If present, add functions for using defaults without the factory, e.g. DefaultAnimal() and (*Animal).SetDefaults()")
+	fs.BoolVar(&c.JSON, "json", c.JSON, "If present, add an UnmarshalJSON method starting from the defaults")
+	fs.BoolVar(&c.Flags, "flags", c.Flags, "If present, add a function declaring flags for your options, e.g. RegisterAnimalFlags(fs *flag.FlagSet, prefix string)")
+	fs.BoolVar(&c.FromMap, "from-map", c.FromMap, "If present, add a function turning a map into options, e.g. AnimalOptionsFromMap(m map[string]interface{})")
+	fs.BoolVar(&c.FromStruct, "from-struct", c.FromStruct, "If present, add functions turning a struct into options, e.g. FromAnimal(v Animal) and ToOptions(v *Animal)")
+	fs.BoolVar(&c.Schema, "schema", c.Schema, "If present, also write a JSON Schema describing your options, e.g. animal.schema.json")
+	fs.StringVar(&c.Output, "output", c.Output, "Template for the names of the generated files, given the {{.Type}} and its {{.Package}}. Types whose names render the same share a file")
+}
+
+// markerPrefix starts the comment marking a type for generation.
+const markerPrefix = "//funcopgen:generate"
+
+// typeDecl is the declaration of a struct in the package.
+type typeDecl struct {
+	Spec   *ast.TypeSpec
+	Struct *ast.StructType
+
+	// Marker is the type's funcopgen:generate comment, if it has one
+	Marker *ast.Comment
+}
+
+// findTypeDecls returns the structs declared in the package, by name, leaving
+// out the ones in files we've generated, e.g. option trackers.
+func findTypeDecls() map[string]*typeDecl {
+	out := map[string]*typeDecl{}
+
+	for _, file := range pkg.Syntax {
+		if isGenerated(file) {
+			continue
+		}
+
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				s, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+
+				// a lone type's comment belongs to its declaration
+				// rather than its spec
+				doc := ts.Doc
+				if doc == nil && len(gen.Specs) == 1 {
+					doc = gen.Doc
+				}
+
+				out[ts.Name.Name] = &typeDecl{Spec: ts, Struct: s, Marker: findMarker(doc)}
+			}
+		}
+	}
+
+	return out
+}
+
+// findMarker returns the funcopgen:generate comment in the doc comment, if
+// there is one.
+func findMarker(doc *ast.CommentGroup) *ast.Comment {
+	if doc == nil {
+		return nil
+	}
+
+	for _, c := range doc.List {
+		if c.Text == markerPrefix || strings.HasPrefix(c.Text, markerPrefix+" ") {
+			return c
+		}
+	}
+	return nil
+}
+
+// foundTypes are the -type types we've found so far, so we can tell if any
+// aren't in any of the packages.
+var foundTypes = map[string]bool{}
+
+// selectTypes returns the names of the types to generate for, in order. If
+// -type is given, it's those. Otherwise it's every struct with -all, or every
+// struct whose name matches -type-regexp. Failing that, it's the struct right
+// after the go:generate directive we're run from, if there is one, or else
+// every struct with a marker or with settings in the config file.
+func selectTypes(decls map[string]*typeDecl) []string {
+	out := []string{}
+	inferred := inferType()
+
+	switch {
+	case *typeNames != "":
+		for _, t := range strings.Split(*typeNames, ",") {
+			if _, ok := decls[t]; !ok {
+				// with several packages, each type is only in some
+				// of them
+				if multiPackage {
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "Unknown type %q in %q in package %q\n", t, pkg.Name, pkg.PkgPath)
+				os.Exit(1)
+			}
+			out = append(out, t)
+			foundTypes[t] = true
+		}
+	case *all || *typeRegexp != "":
+		re, err := regexp.Compile(*typeRegexp)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Bad -type-regexp: %v\n", err)
+			os.Exit(1)
+		}
+
+		for t, decl := range decls {
+			if !re.MatchString(t) {
+				continue
+			}
+
+			// these weren't asked for by name, so skip any we can't
+			// handle rather than giving up on the rest
+			if field, ok := unsupportedField(decl.Struct); ok {
+				fmt.Fprintf(os.Stderr, "Skipping %s, since the type of its field %s isn't supported\n", t, field)
+				continue
+			}
+			out = append(out, t)
+		}
+	case inferred != "":
+		out = append(out, inferred)
+	default:
+		for t, decl := range decls {
+			if decl.Marker != nil || pkgConfig.hasType(t) {
+				out = append(out, t)
+			}
+		}
+	}
+
+	sort.Strings(out)
+	return out
+}
+
+// unsupportedField returns the first field of the struct whose type we can't
+// generate options for, e.g. an anonymous struct, or false if there isn't one.
+func unsupportedField(s *ast.StructType) (string, bool) {
+	for _, f := range s.Fields.List {
+		if typ := pkg.TypesInfo.TypeOf(f.Type); typ == nil || supportedType(typ) {
+			continue
+		}
+		if len(f.Names) == 0 {
+			return types.ExprString(f.Type), true
+		}
+		return f.Names[0].Name, true
+	}
+
+	return "", false
+}
+
+// configFor returns the config of the type. From least to most important, it
+// comes from the defaults of the flags, the config file, the flags that were
+// actually given, and the type's marker.
+func configFor(decl *typeDecl) *typeConfig {
+	c := typeConfig{Output: defaultOutput}
+
+	pkgConfig.apply(&c, decl.Spec.Name.Name)
+
+	set := flag.NewFlagSet("funcopgen", flag.ContinueOnError)
+	c.register(set)
+	fs.Visit(func(f *flag.Flag) {
+		if set.Lookup(f.Name) != nil {
+			set.Set(f.Name, f.Value.String())
+		}
+	})
+
+	if decl.Marker != nil {
+		args, err := splitMarker(strings.TrimPrefix(decl.Marker.Text, markerPrefix))
+		if err != nil {
+			fatalAt(decl.Marker.Pos(), "bad funcopgen:generate marker: %v", err)
+		}
+
+		fs := flag.NewFlagSet(markerPrefix, flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		c.register(fs)
+
+		for i, arg := range args {
+			args[i] = "-" + arg
+		}
+		if err := fs.Parse(args); err != nil {
+			fatalAt(decl.Marker.Pos(), "bad funcopgen:generate marker: %v", err)
+		}
+		if fs.NArg() > 0 {
+			fatalAt(decl.Marker.Pos(), "bad funcopgen:generate marker: unexpected %q", fs.Arg(0))
+		}
+	}
+
+	c.outputTemplate = parseOutputTemplate(c.Output)
+	return &c
+}
+
+// splitMarker splits the settings of a marker on spaces, e.g. `prefix=With
+// factory` into prefix=With and factory. Values can be quoted if they have
+// spaces of their own, e.g. `output="{{lower .Type}}_options.go"`.
+func splitMarker(s string) ([]string, error) {
+	out := []string{}
+
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		end := strings.IndexAny(s, " \t")
+		if end < 0 {
+			end = len(s)
+		}
+
+		if eq := strings.Index(s, "="); eq >= 0 && eq < end && strings.HasPrefix(s[eq+1:], `"`) {
+			quoted := quotedPrefix(s[eq+1:])
+			value, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("bad quotes in %s", s)
+			}
+			out = append(out, s[:eq+1]+value)
+			s = s[eq+1+len(quoted):]
+			continue
+		}
+
+		out = append(out, s[:end])
+		s = s[end:]
+	}
+
+	return out, nil
+}
+
+// quotedPrefix returns the double-quoted string s starts with, up to its
+// closing quote, or all of s if it isn't closed.
+func quotedPrefix(s string) string {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return s[:i+1]
+		}
+	}
+	return s
+}
+
+// inferType returns the struct declared right after the go:generate directive
+// we're run from, going by the $GOFILE and $GOLINE that go generate sets, or
+// an empty string if there isn't one, e.g. if we're run by hand.
+func inferType() string {
+	line, err := strconv.Atoi(os.Getenv("GOLINE"))
+	if os.Getenv("GOFILE") == "" || err != nil || multiPackage {
+		return ""
+	}
+
+	for _, file := range pkg.Syntax {
+		if filepath.Base(pkg.Fset.File(file.Pos()).Name()) == os.Getenv("GOFILE") {
+			return typeAfter(file, line)
+		}
+	}
+
+	return ""
+}
+
+// typeAfter returns the struct declared right after the line of the file, or
+// an empty string if the next declaration isn't a single struct.
+func typeAfter(file *ast.File, line int) string {
+	// the first declaration after the line, skipping over any comments,
+	// since a doc comment doesn't start its declaration
+	for _, decl := range file.Decls {
+		if pkg.Fset.Position(decl.Pos()).Line <= line {
+			continue
+		}
+
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE || len(gen.Specs) != 1 {
+			return ""
+		}
+		ts := gen.Specs[0].(*ast.TypeSpec)
+		if _, ok := ts.Type.(*ast.StructType); !ok {
+			return ""
+		}
+		return ts.Name.Name
+	}
+
+	return ""
+}
+
+// directedTypes returns the structs of the package right after a go:generate
+// directive running funcopgen without saying which types to generate for, i.e.
+// the ones it infers.
+func directedTypes() map[string]bool {
+	out := map[string]bool{}
+
+	for _, file := range pkg.Syntax {
+		for _, group := range file.Comments {
+			for _, c := range group.List {
+				if !strings.HasPrefix(c.Text, "//go:generate ") || !strings.Contains(c.Text, "funcopgen") {
+					continue
+				}
+				if hasFlag(strings.Fields(c.Text), "type", "type-regexp", "all") {
+					continue
+				}
+
+				if t := typeAfter(file, pkg.Fset.Position(c.Pos()).Line); t != "" {
+					out[t] = true
+				}
+			}
+		}
+	}
+
+	return out
+}
+
+// hasFlag returns whether any of the flags are among the args, e.g. -type in
+// -type=Animal -factory.
+func hasFlag(args []string, flags ...string) bool {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		for _, f := range flags {
+			if name == f {
+				return true
+			}
+		}
+	}
+	return false
+}
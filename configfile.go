@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// configFileName is the name of the config file we look for in the package's
+// directory and every directory above it.
+const configFileName = ".funcopgen.json"
+
+// configScope is a level of a config file: the file itself, one of its
+// packages, or one of their types. Its settings are named after the flags,
+// e.g. {"prefix": "With", "factory": true}.
+type configScope struct {
+	Settings map[string]json.RawMessage
+
+	// Packages are keyed by their directories, relative to the config file,
+	// and are only allowed in the file itself
+	Packages map[string]*configScope
+
+	// Types are keyed by their names, and are allowed in the file for the
+	// types of that name in every package, or in a package
+	Types map[string]*configScope
+}
+
+func (s *configScope) UnmarshalJSON(b []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	s.Settings = map[string]json.RawMessage{}
+
+	for k, v := range raw {
+		var err error
+		switch k {
+		case "packages":
+			err = json.Unmarshal(v, &s.Packages)
+		case "types":
+			err = json.Unmarshal(v, &s.Types)
+		default:
+			s.Settings[k] = v
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", k, err)
+		}
+	}
+
+	return nil
+}
+
+// checkNesting exits if the file has packages or types where they aren't
+// allowed, rather than silently ignoring them.
+func (s *configScope) checkNesting(path string) {
+	for dir, p := range s.Packages {
+		if p.Packages != nil {
+			fmt.Fprintf(os.Stderr, "%s: package %s: packages can only be given at the top of the file\n", path, dir)
+			os.Exit(1)
+		}
+		for name, t := range p.Types {
+			t.checkType(fmt.Sprintf("%s: package %s: type %s", path, dir, name))
+		}
+	}
+
+	for name, t := range s.Types {
+		t.checkType(fmt.Sprintf("%s: type %s", path, name))
+	}
+}
+
+// checkType exits if the settings of a type have packages or types of their
+// own.
+func (s *configScope) checkType(where string) {
+	if s.Packages != nil || s.Types != nil {
+		fmt.Fprintf(os.Stderr, "%s: a type can't have packages or types of its own\n", where)
+		os.Exit(1)
+	}
+}
+
+// apply sets the settings on the config, exiting if any of them are unknown
+// or have the wrong type.
+func (s *configScope) apply(c *typeConfig, where string) {
+	if s == nil {
+		return
+	}
+
+	fs := flag.NewFlagSet(configFileName, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	c.register(fs)
+
+	// go through the settings in order, so the same one is always reported
+	// if several are broken
+	keys := make([]string, 0, len(s.Settings))
+	for k := range s.Settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		value := string(s.Settings[k])
+
+		var str string
+		if err := json.Unmarshal(s.Settings[k], &str); err == nil {
+			value = str
+		}
+
+		if fs.Lookup(k) == nil {
+			fmt.Fprintf(os.Stderr, "%s: unknown setting %q\n", where, k)
+			os.Exit(1)
+		}
+		if err := fs.Set(k, value); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: bad setting %q: %v\n", where, k, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// configFile is the config file found for the package, if any.
+type configFile struct {
+	// Path is where the file is
+	Path string
+
+	// Package is the package's directory relative to the file's, e.g.
+	// internal/server, or . if they're the same
+	Package string
+
+	*configScope
+}
+
+// findConfigFile looks for a config file in the directory and every directory
+// above it, returning the closest one, or nil if there isn't one.
+func findConfigFile(dir string) *configFile {
+	dir = absPath(dir)
+
+	for d := dir; ; d = filepath.Dir(d) {
+		// we don't have a YAML parser, so rather than ignore one
+		// silently, point out the JSON file we'd read instead
+		if _, err := os.Stat(filepath.Join(d, "funcopgen.yaml")); err == nil {
+			fmt.Fprintf(os.Stderr, "%s isn't supported, use %s instead\n", filepath.Join(d, "funcopgen.yaml"), configFileName)
+		}
+
+		path := filepath.Join(d, configFileName)
+		b, err := ioutil.ReadFile(path)
+		if err == nil {
+			scope := &configScope{}
+			if err := json.Unmarshal(b, scope); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				os.Exit(1)
+			}
+			scope.checkNesting(path)
+
+			rel, _ := filepath.Rel(d, dir)
+			return &configFile{Path: path, Package: filepath.ToSlash(rel), configScope: scope}
+		}
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Couldn't read %s: %v\n", path, err)
+			os.Exit(1)
+		}
+
+		if filepath.Dir(d) == d {
+			return nil
+		}
+	}
+}
+
+// apply sets the settings of the file for the type on the config, from the
+// file's own settings, to the file's for types of that name, to its package's,
+// to the type's in the package.
+func (f *configFile) apply(c *typeConfig, t string) {
+	if f == nil {
+		return
+	}
+
+	f.configScope.apply(c, f.Path)
+	f.Types[t].apply(c, fmt.Sprintf("%s: type %s", f.Path, t))
+
+	if p := f.Packages[f.Package]; p != nil {
+		p.apply(c, fmt.Sprintf("%s: package %s", f.Path, f.Package))
+		p.Types[t].apply(c, fmt.Sprintf("%s: package %s: type %s", f.Path, f.Package, t))
+	}
+}
+
+// hasType returns whether the file has settings for the type, either for
+// every package or for ours, which marks it for generation much like a marker
+// does.
+func (f *configFile) hasType(t string) bool {
+	if f == nil {
+		return false
+	}
+
+	if f.Types[t] != nil {
+		return true
+	}
+
+	p := f.Packages[f.Package]
+	return p != nil && p.Types[t] != nil
+}
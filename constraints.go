@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/dave/jennifer/jen"
+)
+
+// constraint is a relation between the options of two fields, from a field's
+// `funcop` tag, e.g. `funcop:"excludes=Token"` or `funcop:"requires=TLSCert"`.
+type constraint struct {
+	// Kind is either "excludes" or "requires"
+	Kind  string
+	Field string
+	Other string
+}
+
+// findConstraints collects the option constraints of the type's fields,
+// exiting if any of them refer to fields without options.
+func findConstraints(t *target) []constraint {
+	out := []constraint{}
+	seen := map[string]bool{}
+
+	for _, field := range t.Keys {
+		data := t.Fields[field]
+		funcop := findFuncopTag(data)
+
+		for _, kind := range []string{"excludes", "requires"} {
+			value, ok := funcop[kind]
+			if !ok {
+				continue
+			}
+			if _, ok := t.optionFuncName(field); !ok {
+				fieldFatalf(data, "funcop %s is set on %s, but it has no option", kind, field)
+			}
+			if len(strings.Fields(value)) == 0 {
+				fieldFatalf(data, "funcop %s needs at least one field", kind)
+			}
+
+			for _, other := range strings.Fields(value) {
+				if _, ok := t.Fields[other]; !ok {
+					fieldFatalf(data, "funcop %s refers to %s, which isn't a field of %s", kind, other, t.Name)
+				}
+				if _, ok := t.optionFuncName(other); !ok {
+					fieldFatalf(data, "funcop %s refers to %s, but it has no option", kind, other)
+				}
+				if other == field {
+					fieldFatalf(data, "funcop %s refers to the field itself", kind)
+				}
+
+				// exclusion goes both ways, so only report it once
+				key := fmt.Sprintf("%s %s %s", kind, field, other)
+				if kind == "excludes" && other < field {
+					key = fmt.Sprintf("%s %s %s", kind, other, field)
+				}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				out = append(out, constraint{Kind: kind, Field: field, Other: other})
+			}
+		}
+	}
+
+	return out
+}
+
+// trackerVar is the name of the variable tracking the options applied by the
+// factory of the type.
+func trackerVar(t *target) string {
+	return lowerFirst(t.Name) + "Applied"
+}
+
+func lowerFirst(s string) string {
+	r := firstRune(s)
+	return strings.ToLower(string(r)) + s[len(string(r)):]
+}
+
+// addTracker adds the tracker recording which options have been applied to the
+// structs being built by the factory. Options are plain functions, so the only
+// way for the factory to know which of them were used is for the options
+// themselves to mark it, and for reset options to unmark it. Options applied
+// outside of the factory aren't tracked.
+func addTracker(f *File, t *target) {
+	trackerType := lowerFirst(t.Name) + "OptionTracker"
+	appliedMap := func() *Statement { return Map(Op("*").Id(t.Name)).Map(String()).Bool() }
+	recv := Id("t").Op("*").Id(trackerType)
+
+	lock := func(g *Group) {
+		g.Id("t").Dot("Lock").Call()
+		g.Defer().Id("t").Dot("Unlock").Call()
+		g.Line()
+	}
+
+	f.Commentf("%s records which options have been applied to the %ss being built by New%s.", trackerType, t.Name, t.Name)
+	f.Type().Id(trackerType).Struct(
+		Qual("sync", "Mutex"),
+		Id("applied").Add(appliedMap()),
+	)
+	f.Line()
+
+	f.Var().Id(trackerVar(t)).Op("=").Op("&").Id(trackerType).Values(Dict{
+		Id("applied"): appliedMap().Values(),
+	})
+	f.Line()
+
+	f.Func().Params(recv.Clone()).Id("track").Params(Id("o").Op("*").Id(t.Name)).Map(String()).Bool().BlockFunc(func(g *Group) {
+		lock(g)
+		g.Id("applied").Op(":=").Map(String()).Bool().Values()
+		g.Id("t").Dot("applied").Index(Id("o")).Op("=").Id("applied")
+		g.Return(Id("applied"))
+	})
+	f.Line()
+
+	f.Func().Params(recv.Clone()).Id("untrack").Params(Id("o").Op("*").Id(t.Name)).BlockFunc(func(g *Group) {
+		lock(g)
+		g.Delete(Id("t").Dot("applied"), Id("o"))
+	})
+	f.Line()
+
+	f.Func().Params(recv.Clone()).Id("mark").Params(Id("o").Op("*").Id(t.Name), Id("field").String(), Id("applied").Bool()).BlockFunc(func(g *Group) {
+		lock(g)
+		g.If(Id("fields, ok").Op(":=").Id("t").Dot("applied").Index(Id("o")), Id("ok")).Block(
+			Id("fields").Index(Id("field")).Op("=").Id("applied"),
+		)
+	})
+	f.Line()
+}
+
+// constraintChecks generates the factory's checks of the constraints against
+// the options that were applied, aggregating every violation into one error.
+func constraintChecks(g *Group, t *target) {
+	g.Var().Id("errs").Index().String()
+	for _, c := range t.Constraints {
+		option, _ := t.optionFuncName(c.Field)
+		other, _ := t.optionFuncName(c.Other)
+
+		switch c.Kind {
+		case "excludes":
+			g.If(Id("applied").Index(Lit(c.Field)).Op("&&").Id("applied").Index(Lit(c.Other))).Block(
+				Id("errs").Op("=").Append(Id("errs"), Lit(option+" can't be used together with "+other)),
+			)
+		case "requires":
+			g.If(Id("applied").Index(Lit(c.Field)).Op("&&").Op("!").Id("applied").Index(Lit(c.Other))).Block(
+				Id("errs").Op("=").Append(Id("errs"), Lit(option+" requires "+other)),
+			)
+		}
+	}
+	g.If(Len(Id("errs")).Op(">").Lit(0)).Block(
+		Return(Nil(), Qual("fmt", "Errorf").Call(Lit("invalid "+t.Name+" options: %s"), Qual("strings", "Join").Call(Id("errs"), Lit("; ")))),
+	)
+}
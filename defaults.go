@@ -0,0 +1,648 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/dave/jennifer/jen"
+	"github.com/fatih/structtag"
+)
+
+// defaultCode parses the value of a `default` tag into an expression of the
+// given type, or returns an error if the value doesn't fit the type.
+//
+// Basic types, named types with basic underlying types, time.Duration strings
+// like "5s", and pointers to any of those are supported. Slices, arrays, maps
+// and structs can be given as JSON, e.g. `default:"[\"a\",\"b\"]"`. Anything
+// else, including values that aren't literals of the type, is parsed as a Go
+// expression. Plain strings are always literals though, while other basic
+// types look for a constant or variable named by the default first, e.g.
+// `default:"ModeDebug"`. Defaults starting with "func:" name a function to call
+// for the default, see funcDefaultCode.
+//
+// The position is where the default came from, used to resolve the imports of
+// the file it's in.
+func defaultCode(typ types.Type, value string, pos token.Pos) (Code, error) {
+	if strings.HasPrefix(value, "func:") {
+		return funcDefaultCode(typ, strings.TrimPrefix(value, "func:"), pos)
+	}
+
+	if typ == nil {
+		return exprCode(nil, value, pos)
+	}
+
+	if isDuration(typ) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return orExprCode(typ, value, pos, fmt.Errorf("%q isn't a valid time.Duration, e.g. 5s or 1h30m", value))
+		}
+		return durationCode(d), nil
+	}
+
+	if hasJSONDefault(typ) && json.Valid([]byte(value)) {
+		if err := checkJSONKeys(value); err != nil {
+			return nil, err
+		}
+
+		dec := json.NewDecoder(strings.NewReader(value))
+		dec.UseNumber()
+
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		return jsonCode(typ, v, "", pos)
+	}
+
+	switch t := typ.Underlying().(type) {
+	case *types.Basic:
+		// a named type's default is often one of its constants, e.g.
+		// ModeDebug for a Mode, while plain strings are always literals
+		if !types.Identical(typ, types.Typ[types.String]) {
+			if code, ok := namedValueCode(typ, value, pos); ok {
+				return code, nil
+			}
+		}
+
+		lit, err := constLit(t, value)
+		if err != nil {
+			return orExprCode(typ, value, pos, err)
+		}
+		return lit, nil
+	case *types.Pointer:
+		if !hasTypedDefault(t.Elem()) {
+			break
+		}
+
+		elem, err := defaultCode(t.Elem(), value, pos)
+		if err != nil {
+			return nil, err
+		}
+
+		// we can't take the address of a constant, so we need a variable
+		return Func().Params().Add(jenTypeOf(typ)).Block(
+			Var().Id("v").Add(jenTypeOf(t.Elem())).Op("=").Add(elem),
+			Return(Op("&").Id("v")),
+		).Call(), nil
+	}
+
+	return exprCode(typ, value, pos)
+}
+
+// namedValueCode returns the constant or variable named by a default, e.g.
+// `default:"ModeDebug"` or `default:"os.ModePerm"`, if it's resolved at pos to
+// one that can be used as the type.
+func namedValueCode(typ types.Type, value string, pos token.Pos) (Code, bool) {
+	expr, err := parser.ParseExpr(value)
+	if err != nil {
+		return nil, false
+	}
+
+	switch e := expr.(type) {
+	case *ast.Ident:
+	case *ast.SelectorExpr:
+		if _, ok := e.X.(*ast.Ident); !ok {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+
+	code, tv, err := resolveExpr(value, pos)
+	if err != nil || tv.Value == nil && !tv.Addressable() || !types.AssignableTo(tv.Type, typ) {
+		return nil, false
+	}
+	return code, true
+}
+
+// funcDefaultCode returns a call to the named function for a default given as
+// `default:"func:defaultLogger"`, or `default:"func:pkg.Func"` for a function
+// from another package imported by the file at pos, or imported anywhere in
+// our package if given as a full import path. The function has to take no
+// arguments and return a single value assignable to the type.
+func funcDefaultCode(typ types.Type, name string, pos token.Pos) (Code, error) {
+	p := pkgAt(pos)
+	if p == nil {
+		return nil, fmt.Errorf("can't tell which package func %s is from", name)
+	}
+
+	scope := p.Scope()
+	call := Id(name).Call()
+	lookup := name
+
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		qualifier, funcName := name[:i], name[i+1:]
+
+		importPath, ok := importPathOf(fileOf(pos), qualifier)
+		if !ok {
+			importPath, ok = importNamed(p, qualifier)
+		}
+		if !ok {
+			importPath = qualifier
+		}
+
+		scope = nil
+		for _, imported := range p.Imports() {
+			if imported.Path() == importPath {
+				scope = imported.Scope()
+			}
+		}
+		if scope == nil {
+			return nil, fmt.Errorf("can't find package %q for func %s, is it imported?", qualifier, name)
+		}
+		if !token.IsExported(funcName) {
+			return nil, fmt.Errorf("func %s isn't exported", name)
+		}
+
+		lookup = funcName
+		call = Qual(importPath, funcName).Call()
+	} else if p != pkg.Types {
+		// another package's own functions have to be qualified with it
+		if !token.IsExported(name) {
+			return nil, fmt.Errorf("func %s of %s isn't exported", name, p.Path())
+		}
+		call = Qual(p.Path(), name).Call()
+	}
+
+	fn, ok := scope.Lookup(lookup).(*types.Func)
+	if !ok {
+		return nil, fmt.Errorf("can't find func %s", name)
+	}
+
+	sig := fn.Type().(*types.Signature)
+	if sig.Params().Len() != 0 {
+		return nil, fmt.Errorf("func %s can't take any arguments", name)
+	}
+	if sig.Results().Len() != 1 {
+		return nil, fmt.Errorf("func %s has to return a single value", name)
+	}
+	if result := sig.Results().At(0).Type(); typ != nil && !types.AssignableTo(result, typ) {
+		return nil, fmt.Errorf("func %s returns %s, which can't be used as %s", name, result, typ)
+	}
+
+	return call, nil
+}
+
+// orExprCode falls back to parsing a default as an expression when it isn't a
+// literal of the type, e.g. for something like `default:"maxRetries * 2"`.
+// If it isn't an expression either, both reasons are reported.
+func orExprCode(typ types.Type, value string, pos token.Pos, litErr error) (Code, error) {
+	code, err := exprCode(typ, value, pos)
+	if err != nil {
+		return nil, fmt.Errorf("%v, and %v", litErr, err)
+	}
+	return code, nil
+}
+
+// hasTypedDefault returns whether defaultCode parses defaults of the given
+// type itself, rather than pasting them as is.
+func hasTypedDefault(typ types.Type) bool {
+	switch t := typ.Underlying().(type) {
+	case *types.Basic:
+		return true
+	case *types.Pointer:
+		return hasTypedDefault(t.Elem())
+	}
+	return false
+}
+
+// hasJSONDefault returns whether defaults of the given type can be given as
+// JSON, i.e. if it's a composite type or a pointer to one.
+func hasJSONDefault(typ types.Type) bool {
+	switch t := typ.Underlying().(type) {
+	case *types.Slice, *types.Array, *types.Map, *types.Struct, *types.Interface:
+		return true
+	case *types.Pointer:
+		return hasJSONDefault(t.Elem())
+	}
+	return false
+}
+
+func isDuration(typ types.Type) bool {
+	named, ok := types.Unalias(typ).(*types.Named)
+	if !ok {
+		return false
+	}
+
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == "time" && obj.Name() == "Duration"
+}
+
+// durationCode renders the duration in the largest unit dividing it evenly,
+// e.g. 90 * time.Second rather than 90000000000.
+func durationCode(d time.Duration) Code {
+	if d == 0 {
+		return Lit(0)
+	}
+
+	units := []struct {
+		unit time.Duration
+		name string
+	}{
+		{time.Hour, "Hour"},
+		{time.Minute, "Minute"},
+		{time.Second, "Second"},
+		{time.Millisecond, "Millisecond"},
+		{time.Microsecond, "Microsecond"},
+		{time.Nanosecond, "Nanosecond"},
+	}
+
+	for _, u := range units {
+		if d%u.unit == 0 {
+			return Op(strconv.FormatInt(int64(d/u.unit), 10)).Op("*").Qual("time", u.name)
+		}
+	}
+
+	return nil
+}
+
+// checkJSONKeys returns an error if an object in the JSON value has the same
+// key twice, since decoding it would quietly keep only the last one.
+func checkJSONKeys(value string) error {
+	dec := json.NewDecoder(strings.NewReader(value))
+
+	var check func(path string) error
+	check = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch tok {
+		case json.Delim('{'):
+			keys := map[string]bool{}
+			for dec.More() {
+				tok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key := tok.(string)
+				if keys[key] {
+					at := ""
+					if path != "" {
+						at = " at " + path
+					}
+					return fmt.Errorf("key %q is given twice%s", key, at)
+				}
+				keys[key] = true
+
+				if err := check(fmt.Sprintf("%s[%q]", path, key)); err != nil {
+					return err
+				}
+			}
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := check(fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		default:
+			return nil
+		}
+
+		// the closing delimiter
+		_, err = dec.Token()
+		return err
+	}
+
+	return check("")
+}
+
+// jsonCode turns a decoded JSON value into a composite literal of the given
+// type, checking it against the type all the way down. The path is where we
+// are in the value, e.g. [0]["key"].Name, for pointing out mistakes.
+func jsonCode(typ types.Type, v interface{}, path string, pos token.Pos) (Code, error) {
+	at := ""
+	if path != "" {
+		at = " at " + path
+	}
+
+	mismatch := func() error {
+		return fmt.Errorf("can't use JSON %s%s as %s", jsonKind(v), at, typ)
+	}
+
+	if v == nil {
+		switch typ.Underlying().(type) {
+		case *types.Pointer, *types.Slice, *types.Map, *types.Interface:
+			return Nil(), nil
+		}
+		return nil, mismatch()
+	}
+
+	if isDuration(typ) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, mismatch()
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q%s isn't a valid time.Duration", s, at)
+		}
+		return durationCode(d), nil
+	}
+
+	switch t := typ.Underlying().(type) {
+	case *types.Basic:
+		var s string
+		switch v := v.(type) {
+		case string:
+			if t.Info()&types.IsString == 0 {
+				return nil, mismatch()
+			}
+			s = v
+		case json.Number:
+			if t.Info()&types.IsNumeric == 0 {
+				return nil, mismatch()
+			}
+			s = v.String()
+		case bool:
+			if t.Info()&types.IsBoolean == 0 {
+				return nil, mismatch()
+			}
+			s = strconv.FormatBool(v)
+		default:
+			return nil, mismatch()
+		}
+
+		lit, err := constLit(t, s)
+		if err != nil {
+			return nil, fmt.Errorf("%v%s", err, at)
+		}
+		return lit, nil
+
+	case *types.Pointer:
+		elem, err := jsonCode(t.Elem(), v, path, pos)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := t.Elem().Underlying().(*types.Struct); ok {
+			return Op("&").Add(elem), nil
+		}
+		return Func().Params().Add(jenTypeOf(typ)).Block(
+			Var().Id("v").Add(jenTypeOf(t.Elem())).Op("=").Add(elem),
+			Return(Op("&").Id("v")),
+		).Call(), nil
+
+	case *types.Slice, *types.Array:
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, mismatch()
+		}
+
+		var elemType types.Type
+		switch t := t.(type) {
+		case *types.Slice:
+			elemType = t.Elem()
+		case *types.Array:
+			elemType = t.Elem()
+			if int64(len(list)) > t.Len() {
+				return nil, fmt.Errorf("too many elements%s for %s", at, typ)
+			}
+		}
+
+		elems := []Code{}
+		for i, e := range list {
+			code, err := jsonCode(elemType, e, fmt.Sprintf("%s[%d]", path, i), pos)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, code)
+		}
+		return jenTypeOf(typ).Values(elems...), nil
+
+	case *types.Map:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, mismatch()
+		}
+
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		d := Dict{}
+		for _, k := range keys {
+			key, err := defaultCode(t.Key(), k, pos)
+			if _, ok := t.Key().Underlying().(*types.Basic); !ok || err != nil {
+				return nil, fmt.Errorf("can't use key %q%s as %s", k, at, t.Key())
+			}
+			value, err := jsonCode(t.Elem(), obj[k], fmt.Sprintf("%s[%q]", path, k), pos)
+			if err != nil {
+				return nil, err
+			}
+			d[key] = value
+		}
+		return jenTypeOf(typ).Values(d), nil
+
+	case *types.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, mismatch()
+		}
+		if _, ok := types.Unalias(typ).(*types.Named); !ok {
+			return nil, fmt.Errorf("can't make a default for an anonymous struct")
+		}
+
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		// a field can be named twice, by its name and by its json tag
+		assigned := map[*types.Var]string{}
+
+		d := Dict{}
+		for _, key := range keys {
+			value := obj[key]
+			field := structFieldByKey(t, key)
+			if field == nil {
+				return nil, fmt.Errorf("%s has no field %q", typ, key)
+			}
+			if other, ok := assigned[field]; ok {
+				return nil, fmt.Errorf("field %s of %s is given twice%s, as %q and %q", field.Name(), typ, at, other, key)
+			}
+			assigned[field] = key
+			if !field.Exported() && field.Pkg().Path() != pkg.PkgPath {
+				return nil, fmt.Errorf("field %s of %s isn't exported", field.Name(), typ)
+			}
+
+			code, err := jsonCode(field.Type(), value, path+"."+field.Name(), pos)
+			if err != nil {
+				return nil, err
+			}
+			d[Id(field.Name())] = code
+		}
+		return jenTypeOf(typ).Values(d), nil
+
+	case *types.Interface:
+		if !t.Empty() {
+			return nil, mismatch()
+		}
+
+		// without anything better to go on, use the types encoding/json
+		// would have decoded into
+		switch v := v.(type) {
+		case string:
+			return jsonCode(types.Typ[types.String], v, path, pos)
+		case json.Number:
+			f, err := v.Float64()
+			if err != nil {
+				return nil, fmt.Errorf("%v%s", err, at)
+			}
+			return Lit(f), nil
+		case bool:
+			return jsonCode(types.Typ[types.Bool], v, path, pos)
+		case []interface{}:
+			return jsonCode(types.NewSlice(typ), v, path, pos)
+		case map[string]interface{}:
+			return jsonCode(types.NewMap(types.Typ[types.String], typ), v, path, pos)
+		}
+	}
+
+	return nil, mismatch()
+}
+
+// structFieldByKey finds the struct's field for a JSON key, either by its name
+// or by the name in its `json` tag.
+func structFieldByKey(s *types.Struct, key string) *types.Var {
+	for i := 0; i < s.NumFields(); i++ {
+		field := s.Field(i)
+		name := strings.Split(reflect.StructTag(s.Tag(i)).Get("json"), ",")[0]
+		if field.Name() == key || name == key {
+			return field
+		}
+	}
+	return nil
+}
+
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "bool"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "null"
+}
+
+// nestedDefault returns the default for a struct from the defaults of its own
+// fields, all the way down, or false if none of them have any. If the struct is
+// one of our targets with a factory that can't fail, the factory is used
+// instead when factories is set.
+//
+// Pointers to structs are only initialized if asked to with init, i.e. if the
+// field has a `funcop:"init"` tag. Since pointers can make for cycles between
+// types, we guard against those with seen, and don't use factories beneath
+// pointers, where a cycle would only blow up at runtime.
+func nestedDefault(typ types.Type, init, factories bool, seen map[types.Type]bool) (Code, bool) {
+	typ = types.Unalias(typ)
+	if ptr, ok := typ.(*types.Pointer); ok {
+		if !init || seen[ptr.Elem()] {
+			return nil, false
+		}
+		if _, ok := ptr.Elem().Underlying().(*types.Struct); !ok {
+			return nil, false
+		}
+
+		elem, ok := nestedDefault(ptr.Elem(), false, false, seen)
+		if !ok {
+			elem = jenTypeOf(ptr.Elem()).Values()
+		}
+		return Op("&").Add(elem), true
+	}
+
+	named, ok := typ.(*types.Named)
+	if !ok || seen[named] {
+		return nil, false
+	}
+	s, ok := named.Underlying().(*types.Struct)
+	if !ok {
+		return nil, false
+	}
+
+	if call, ok := factoryCall(named); ok && factories {
+		return Op("*").Add(call), true
+	}
+
+	seen[named] = true
+	defer delete(seen, named)
+
+	d := Dict{}
+	for i := 0; i < s.NumFields(); i++ {
+		field := s.Field(i)
+		if !field.Exported() && field.Pkg() != pkg.Types {
+			continue
+		}
+
+		tags, err := structtag.Parse(s.Tag(i))
+		if err != nil {
+			fatalAt(field.Pos(), "bad tags for %s: %v", field.Name(), err)
+		}
+
+		if tag, _ := tags.Get("default"); tag != nil {
+			if code, ok := nestedFieldDefault(field, tag.Value()); ok {
+				d[Id(field.Name())] = code
+			}
+			continue
+		}
+
+		if code, ok := nestedDefault(field.Type(), hasFuncopInit(tags), factories, seen); ok {
+			d[Id(field.Name())] = code
+		}
+	}
+
+	if len(d) == 0 {
+		return nil, false
+	}
+
+	return jenTypeOf(named).Values(d), true
+}
+
+// nestedFieldDefault returns the default of a field of a nested struct,
+// exiting if it's bad. The default of a field of a struct from another package
+// is resolved in that package, but if it can't be, e.g. if it uses something
+// unexported, the field is skipped rather than failing whatever embeds it.
+func nestedFieldDefault(field *types.Var, value string) (Code, bool) {
+	code, err := defaultCode(field.Type(), value, field.Pos())
+	if err != nil {
+		if field.Pkg() != pkg.Types {
+			return nil, false
+		}
+		fatalAt(field.Pos(), "bad default for %s: %v", field.Name(), err)
+	}
+	return code, true
+}
+
+// factoryCall returns a call to the factory we're generating for the type, if
+// we are and it can't fail.
+func factoryCall(typ types.Type) (*Statement, bool) {
+	named, ok := types.Unalias(typ).(*types.Named)
+	if !ok || named.Obj().Pkg() != pkg.Types {
+		return nil, false
+	}
+
+	t, ok := targets[named.Obj().Name()]
+	if !ok || !t.Config.Factory || t.needsErr() {
+		return nil, false
+	}
+
+	return Id("New" + t.Name).Call(), true
+}
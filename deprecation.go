@@ -0,0 +1,74 @@
+package main
+
+import (
+	"go/token"
+	"strings"
+
+	. "github.com/dave/jennifer/jen"
+)
+
+// findDeprecation returns the notice from a field's `deprecated` tag, e.g.
+// `deprecated:"Use WithHostname instead."`, or false if it isn't deprecated.
+func findDeprecation(data *FieldData) (string, bool) {
+	tag, _ := data.Tags.Get("deprecated")
+	if tag == nil {
+		return "", false
+	}
+
+	// the notice is free text, so put back any commas structtag split on
+	notice := strings.TrimSpace(tag.Value())
+	if notice == "" {
+		notice = "This option will be removed in a future version."
+	}
+
+	return notice, true
+}
+
+// findAliases returns the old names of the field's option from its `funcop`
+// tag, e.g. `funcop:"alias=WithColour"`, exiting if any of them would clash
+// with the type's other options.
+func findAliases(t *target, field string) []string {
+	data := t.Fields[field]
+
+	value, ok := findFuncopTag(data)["alias"]
+	if !ok {
+		return nil
+	}
+
+	if _, ok := t.optionFuncName(field); !ok {
+		fieldFatalf(data, "funcop alias is set on %s, but it has no option", field)
+	}
+	if len(strings.Fields(value)) == 0 {
+		fieldFatalf(data, "funcop alias needs at least one name")
+	}
+
+	options := map[string]bool{}
+	for _, other := range t.Keys {
+		if name, ok := t.optionFuncName(other); ok {
+			options[name] = true
+		}
+	}
+
+	for _, alias := range strings.Fields(value) {
+		if !token.IsIdentifier(alias) {
+			fieldFatalf(data, "funcop alias %q isn't a valid name", alias)
+		}
+		if options[alias] {
+			fieldFatalf(data, "funcop alias %s clashes with an option of the same name", alias)
+		}
+	}
+
+	return strings.Fields(value)
+}
+
+// aliasFunc generates a deprecated option with an old name of the field's
+// option, delegating to the current one. This way renaming a field doesn't
+// break code still using its old option right away.
+func aliasFunc(t *target, field, alias string) *Statement {
+	optionFunc, _ := t.optionFuncName(field)
+
+	return Commentf("Deprecated: Use %s instead.", optionFunc).Line().
+		Func().Id(alias).Params(Id("x").Add(t.Fields[field].Type)).Id(t.Option).Block(
+		Return(Id(optionFunc).Call(Id("x"))),
+	)
+}
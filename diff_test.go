@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{
+			name: "same",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			want: "",
+		},
+		{
+			name: "changed line",
+			a:    "a\nb\nc\n",
+			b:    "a\nB\nc\n",
+			want: "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "from nothing",
+			a:    "",
+			b:    "a\nb\n",
+			want: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "to nothing",
+			a:    "a\n",
+			b:    "",
+			want: "--- a\n+++ b\n@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "two hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			want: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			name: "no newline on disk",
+			a:    "a\nb",
+			b:    "a\nb\n",
+			want: "--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name: "no newline generated",
+			a:    "a\nb\n",
+			b:    "a\nc",
+			want: "--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-b\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			name: "no newline on either",
+			a:    "a\nb",
+			b:    "A\nb",
+			want: "--- a\n+++ b\n@@ -1,2 +1,2 @@\n-a\n+A\n b\n\\ No newline at end of file\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unifiedDiff("a", "b", []byte(tt.a), []byte(tt.b))
+			if got != tt.want {
+				t.Fatalf("unifiedDiff(%q, %q) =\n%s\nwant\n%s", tt.a, tt.b, got, tt.want)
+			}
+
+			patched, err := applyDiff(tt.a, got)
+			if err != nil {
+				t.Fatalf("applying the diff: %v", err)
+			}
+			if patched != tt.b {
+				t.Fatalf("applying the diff to %q gave %q, want %q", tt.a, patched, tt.b)
+			}
+		})
+	}
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+\d+(?:,\d+)? @@\n$`)
+
+// applyDiff applies a unified diff to a, the way patch would.
+func applyDiff(a, diff string) (string, error) {
+	if diff == "" {
+		return a, nil
+	}
+
+	type line struct {
+		op   byte
+		text string
+	}
+	type hunk struct {
+		start int
+		lines []line
+	}
+
+	hunks := []*hunk{}
+	for _, l := range splitLines(diff)[2:] {
+		if m := hunkHeader.FindStringSubmatch(l); m != nil {
+			start, _ := strconv.Atoi(m[1])
+			// an empty hunk starts at the line before it
+			if m[2] != "0" {
+				start--
+			}
+			hunks = append(hunks, &hunk{start: start})
+			continue
+		}
+
+		h := hunks[len(hunks)-1]
+		if l == "\\ No newline at end of file\n" {
+			prev := &h.lines[len(h.lines)-1]
+			prev.text = strings.TrimSuffix(prev.text, "\n")
+			continue
+		}
+		h.lines = append(h.lines, line{l[0], l[1:]})
+	}
+
+	lines := splitLines(a)
+	out := &strings.Builder{}
+	i := 0
+
+	for _, h := range hunks {
+		for ; i < h.start; i++ {
+			out.WriteString(lines[i])
+		}
+		for _, l := range h.lines {
+			if l.op != '+' {
+				if i >= len(lines) || lines[i] != l.text {
+					return "", fmt.Errorf("line %d doesn't match %q", i+1, l.text)
+				}
+				i++
+			}
+			if l.op != '-' {
+				out.WriteString(l.text)
+			}
+		}
+	}
+	for ; i < len(lines); i++ {
+		out.WriteString(lines[i])
+	}
+
+	return out.String(), nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+	"os"
+	"strings"
+)
+
+// docsMarkers returns the comments marking the start and end of the type's
+// table of options in a Markdown file.
+func docsMarkers(t string) (string, string) {
+	return fmt.Sprintf("<!-- funcopgen:begin %s -->", t), fmt.Sprintf("<!-- funcopgen:end %s -->", t)
+}
+
+// docsTable renders a Markdown table describing the type's options, between
+// its markers.
+func docsTable(t *target) string {
+	begin, end := docsMarkers(t.Name)
+
+	b := &strings.Builder{}
+	fmt.Fprintln(b, begin)
+	fmt.Fprintln(b, "| Option | Parameter | Field | Default | Description |")
+	fmt.Fprintln(b, "| ------ | --------- | ----- | ------- | ----------- |")
+
+	qualifier := func(p *types.Package) string {
+		if p == pkg.Types {
+			return ""
+		}
+		return p.Name()
+	}
+
+	for _, field := range t.Keys {
+		data := t.Fields[field]
+
+		optionFunc, ok := t.optionFuncName(field)
+		if !ok {
+			continue
+		}
+
+		param := fmt.Sprintf("%#v", data.Type)
+		if data.GoType != nil {
+			param = types.TypeString(data.GoType, qualifier)
+		}
+
+		def := ""
+		if tag, _ := data.Tags.Get("default"); tag != nil {
+			value := tag.Value()
+			if strings.HasPrefix(value, "func:") {
+				value = strings.TrimPrefix(value, "func:") + "()"
+			}
+			def = markdownCode(value)
+		}
+
+		desc := fieldDoc(data)
+		if aliases := findAliases(t, field); len(aliases) > 0 {
+			for i, alias := range aliases {
+				aliases[i] = markdownCode(alias)
+			}
+			desc = strings.TrimSpace(fmt.Sprintf("%s Also available as %s, which is deprecated.", desc, strings.Join(aliases, ", ")))
+		}
+		if notice, ok := findDeprecation(data); ok {
+			desc = strings.TrimSpace(desc + " **Deprecated:** " + notice)
+		}
+
+		fmt.Fprintf(b, "| %s | %s | %s | %s | %s |\n",
+			markdownCode(optionFunc), markdownCode(param), markdownCode(field), def, markdownEscape(desc))
+	}
+
+	fmt.Fprint(b, end)
+	return b.String()
+}
+
+// markdownCode renders s as inline code in a table cell.
+func markdownCode(s string) string {
+	return "`" + strings.ReplaceAll(s, "|", `\|`) + "`"
+}
+
+// markdownEscape escapes s for a table cell.
+func markdownEscape(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+// withDocs puts the type's table of options in the contents of the Markdown
+// file, replacing whatever is between its markers, or appending it to the end
+// of the file if they aren't there yet.
+func withDocs(b []byte, t *target, filename string) []byte {
+	table := []byte(docsTable(t))
+	begin, end := docsMarkers(t.Name)
+
+	i := bytes.Index(b, []byte(begin))
+	j := bytes.Index(b, []byte(end))
+
+	switch {
+	case i >= 0 && j > i:
+		return append(b[:i:i], append(table, b[j+len(end):]...)...)
+	case i >= 0 || j >= 0:
+		fmt.Fprintf(os.Stderr, "%s has a broken table for %s, expected %s followed by %s\n", filename, t.Name, begin, end)
+		os.Exit(1)
+	}
+
+	if len(b) > 0 {
+		b = append(bytes.TrimRight(b, "\n"), "\n\n"...)
+	}
+	return append(append(b, table...), '\n')
+}
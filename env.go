@@ -0,0 +1,139 @@
+package main
+
+import (
+	"go/types"
+	"strings"
+
+	. "github.com/dave/jennifer/jen"
+)
+
+// envVar is a field the factory reads from an environment variable, from its
+// `env` tag, e.g. `env:"APP_HOSTS,sep=;"`.
+type envVar struct {
+	Field string
+	Name  string
+
+	// Sep separates the elements of slices, a comma by default
+	Sep string
+}
+
+// findEnvVars collects the fields of the type with `env` tags, exiting if any
+// of them can't be parsed from a string.
+func findEnvVars(t *target) []envVar {
+	out := []envVar{}
+
+	for _, field := range t.Keys {
+		data := t.Fields[field]
+
+		tag, _ := data.Tags.Get("env")
+		if tag == nil {
+			continue
+		}
+		if tag.Name == "" {
+			fieldFatalf(data, "env tag on %s needs the name of a variable", field)
+		}
+
+		env := envVar{Field: field, Name: tag.Name, Sep: ","}
+		for _, opt := range tag.Options {
+			switch {
+			case strings.HasPrefix(opt, "sep="):
+				env.Sep = strings.TrimPrefix(opt, "sep=")
+			default:
+				fieldFatalf(data, "unknown env tag option %q", opt)
+			}
+		}
+
+		typ := data.GoType
+		if typ != nil && !isDuration(typ) {
+			if slice, ok := typ.Underlying().(*types.Slice); ok {
+				if env.Sep == "" {
+					fieldFatalf(data, "env separator for %s can't be empty", field)
+				}
+				typ = slice.Elem()
+			}
+		}
+		if typ == nil || !parsesFromString(typ) {
+			fieldFatalf(data, "%s can't be read from an environment variable, since it's a %s", field, data.GoType)
+		}
+
+		out = append(out, env)
+	}
+
+	return out
+}
+
+// parsesFromString returns whether we know how to parse the type from a
+// string, i.e. if it's time.Duration or its underlying type is a string, a
+// bool or a real number.
+func parsesFromString(typ types.Type) bool {
+	if isDuration(typ) {
+		return true
+	}
+
+	basic, ok := typ.Underlying().(*types.Basic)
+	return ok && basic.Info()&(types.IsString|types.IsBoolean|types.IsInteger|types.IsFloat) != 0
+}
+
+// envReads generates the factory's reads of the environment variables into
+// their fields, returning an error from the factory if they can't be parsed.
+func envReads(g *Group, t *target) {
+	for _, env := range t.Envs {
+		typ := t.Fields[env.Field].GoType
+		dst := func() *Statement { return Id("o").Dot(env.Field) }
+		fail := func() *Statement {
+			return Return(Nil(), Qual("fmt", "Errorf").Call(Lit("parsing $"+env.Name+" for "+env.Field+": %w"), Err()))
+		}
+
+		g.If(Id("v, ok").Op(":=").Qual("os", "LookupEnv").Call(Lit(env.Name)), Id("ok")).BlockFunc(func(g *Group) {
+			slice, ok := typ.Underlying().(*types.Slice)
+			if !ok || isDuration(typ) {
+				parseString(g, typ, Id("v"), fail, func(x Code) Code { return dst().Op("=").Add(x) })
+				return
+			}
+
+			g.Add(dst()).Op("=").Nil()
+			g.If(Id("v").Op("!=").Lit("")).Block(
+				For(Id("_, s").Op(":=").Range().Qual("strings", "Split").Call(Id("v"), Lit(env.Sep))).BlockFunc(func(g *Group) {
+					parseString(g, slice.Elem(), Id("s"), fail, func(x Code) Code { return dst().Op("=").Append(dst(), x) })
+				}),
+			)
+		})
+	}
+}
+
+// parseString generates the parsing of the string src into the type, passing
+// the parsed value to set, and returning fail on errors.
+func parseString(g *Group, typ types.Type, src *Statement, fail func() *Statement, set func(Code) Code) {
+	// parse returns the call parsing src and the type of what it returns
+	var parse *Statement
+	var parsed types.Type
+
+	basic, _ := typ.Underlying().(*types.Basic)
+
+	switch {
+	case isDuration(typ):
+		parse, parsed = Qual("time", "ParseDuration").Call(src), typ
+	case basic.Info()&types.IsString != 0:
+		parse, parsed = nil, types.Typ[types.String]
+	case basic.Info()&types.IsBoolean != 0:
+		parse, parsed = Qual("strconv", "ParseBool").Call(src), types.Typ[types.Bool]
+	case basic.Info()&types.IsUnsigned != 0:
+		parse, parsed = Qual("strconv", "ParseUint").Call(src, Lit(0), Lit(basicBits(basic))), types.Typ[types.Uint64]
+	case basic.Info()&types.IsInteger != 0:
+		parse, parsed = Qual("strconv", "ParseInt").Call(src, Lit(0), Lit(basicBits(basic))), types.Typ[types.Int64]
+	case basic.Info()&types.IsFloat != 0:
+		parse, parsed = Qual("strconv", "ParseFloat").Call(src, Lit(basicBits(basic))), types.Typ[types.Float64]
+	}
+
+	value := src
+	if parse != nil {
+		g.List(Id("x"), Err()).Op(":=").Add(parse)
+		g.If(Err().Op("!=").Nil()).Block(fail())
+		value = Id("x")
+	}
+
+	if !types.Identical(typ, parsed) {
+		value = jenTypeOf(typ).Call(value)
+	}
+	g.Add(set(value))
+}
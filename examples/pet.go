@@ -0,0 +1,11 @@
+package animal
+
+//go:generate go run github.com/andreykaipov/funcopgen
+
+// Pet is generated for because of its marker, which has its own flags.
+//
+//funcopgen:generate prefix=With factory unique-option output="zz_generated.{{lower .Type}}_funcop.go"
+type Pet struct {
+	Nickname string `default:"Rex"`
+	Owner    *Animal
+}
@@ -0,0 +1,64 @@
+package animal
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+//go:generate go run github.com/andreykaipov/funcopgen -type=Server -prefix=With -factory -unique-option -reset -defaults -json -flags -from-map -from-struct -schema -docs=README.md
+
+// Server is an example of a server configured with options.
+type Server struct {
+	// Host is the address to listen on.
+	Host string `default:"localhost" validate:"nonempty" env:"SERVER_HOST"`
+	Port int    `default:"8080" validate:"min=1,max=65535" env:"SERVER_PORT" flag:"listen-port" funcop:"key=listen_port"` // port to listen on
+
+	Mode     string `default:"debug" validate:"oneof=debug release test"`
+	Hostname string `default:"web" validate:"regexp=^[a-z][a-z0-9-]*$" funcop:"alias=WithHostName"`
+	Insecure bool   `deprecated:"TLS certificates are always verified now."`
+
+	Timeout  time.Duration `default:"1m30s" env:"SERVER_TIMEOUT"`
+	Retries  *int          `default:"3"`
+	Compress bool          `default:"true"`
+	Backoff  time.Duration `default:"time.Second * 5"`
+	Workers  int           `default:"runtime.NumCPU() * 2"`
+	Logger   *log.Logger   `default:"func:defaultLogger"`
+	Started  time.Time     `default:"func:time.Now"`
+
+	Peers  []string          `default:"[\"10.0.0.1\",\"10.0.0.2\"]" env:"SERVER_PEERS"`
+	Labels map[string]string `default:"{\"team\":\"core\"}"`
+	Limits *Limits           `default:"{\"rps\":100,\"Burst\":10}"`
+	Extra  Extra
+	Hooks  []interface{ Run() error }
+
+	Pool   Pool
+	Mascot *Animal `funcop:"init"`
+
+	Token     string `funcop:"excludes=BasicAuth" flag:"-"`
+	BasicAuth string
+	TLSCert   string
+	TLSKey    string `funcop:"requires=TLSCert"`
+}
+
+// Extra holds anything else, e.g. for plugins.
+type Extra = map[string]interface{}
+
+type Limits struct {
+	RPS   float64 `json:"rps"`
+	Burst int
+}
+
+func defaultLogger() *log.Logger {
+	return log.New(os.Stderr, "server: ", log.LstdFlags)
+}
+
+type Pool struct {
+	Size    int           `default:"10"`
+	Idle    time.Duration `default:"5m"`
+	Metrics *Metrics      `funcop:"init"`
+}
+
+type Metrics struct {
+	Namespace string `default:"server"`
+}
@@ -28,7 +28,7 @@ type Bounds struct {
 }
 
 type EmbedThis struct {
-	a string
+	a string `default:"embedded"`
 }
 
 // go generate would skip this since it's not in our type list above
@@ -0,0 +1,30 @@
+// Code generated by funcopgen (devel). DO NOT EDIT.
+//
+// Command: funcopgen
+// Source: pet.go
+
+package animal
+
+type PetOption func(*Pet)
+
+func NewPet(opts ...PetOption) *Pet {
+	o := &Pet{Nickname: "Rex"}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+func WithNickname(x string) PetOption {
+	return func(o *Pet) {
+		o.Nickname = x
+	}
+}
+
+func WithOwner(x *Animal) PetOption {
+	return func(o *Pet) {
+		o.Owner = x
+	}
+}
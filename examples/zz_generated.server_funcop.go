@@ -0,0 +1,1030 @@
+// Code generated by funcopgen (devel). DO NOT EDIT.
+//
+// Command: funcopgen -type=Server -prefix=With -factory -unique-option -reset -defaults -json -flags -from-map -from-struct -schema -docs=README.md
+// Source: server.go
+
+package animal
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ServerOption func(*Server)
+
+// serverOptionTracker records which options have been applied to the Servers being built by NewServer.
+type serverOptionTracker struct {
+	sync.Mutex
+	applied map[*Server]map[string]bool
+}
+
+var serverApplied = &serverOptionTracker{applied: map[*Server]map[string]bool{}}
+
+func (t *serverOptionTracker) track(o *Server) map[string]bool {
+	t.Lock()
+	defer t.Unlock()
+
+	applied := map[string]bool{}
+	t.applied[o] = applied
+	return applied
+}
+
+func (t *serverOptionTracker) untrack(o *Server) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.applied, o)
+}
+
+func (t *serverOptionTracker) mark(o *Server, field string, applied bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	if fields, ok := t.applied[o]; ok {
+		fields[field] = applied
+	}
+}
+
+func NewServer(opts ...ServerOption) (*Server, error) {
+	o := &Server{
+		Backoff:  time.Second * 5,
+		Compress: true,
+		Host:     "localhost",
+		Hostname: "web",
+		Labels:   map[string]string{"team": "core"},
+		Limits: &Limits{
+			Burst: 10,
+			RPS:   100,
+		},
+		Logger: defaultLogger(),
+		Mascot: &Animal{
+			Color:   "red",
+			Surname: "n/a",
+		},
+		Mode:  "debug",
+		Peers: []string{"10.0.0.1", "10.0.0.2"},
+		Pool: Pool{
+			Idle:    5 * time.Minute,
+			Metrics: &Metrics{Namespace: "server"},
+			Size:    10,
+		},
+		Port: 8080,
+		Retries: func() *int {
+			var v int = 3
+			return &v
+		}(),
+		Started: time.Now(),
+		Timeout: 90 * time.Second,
+		Workers: runtime.NumCPU() * 2,
+	}
+
+	if v, ok := os.LookupEnv("SERVER_HOST"); ok {
+		o.Host = v
+	}
+	if v, ok := os.LookupEnv("SERVER_PEERS"); ok {
+		o.Peers = nil
+		if v != "" {
+			for _, s := range strings.Split(v, ",") {
+				o.Peers = append(o.Peers, s)
+			}
+		}
+	}
+	if v, ok := os.LookupEnv("SERVER_PORT"); ok {
+		x, err := strconv.ParseInt(v, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing $SERVER_PORT for Port: %w", err)
+		}
+		o.Port = int(x)
+	}
+	if v, ok := os.LookupEnv("SERVER_TIMEOUT"); ok {
+		x, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("parsing $SERVER_TIMEOUT for Timeout: %w", err)
+		}
+		o.Timeout = x
+	}
+
+	applied := serverApplied.track(o)
+	defer serverApplied.untrack(o)
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	var errs []string
+	if applied["TLSKey"] && !applied["TLSCert"] {
+		errs = append(errs, "WithTLSKey requires WithTLSCert")
+	}
+	if applied["Token"] && applied["BasicAuth"] {
+		errs = append(errs, "WithToken can't be used together with WithBasicAuth")
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid Server options: %s", strings.Join(errs, "; "))
+	}
+
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func DefaultServer() Server {
+	return Server{
+		Backoff:  time.Second * 5,
+		Compress: true,
+		Host:     "localhost",
+		Hostname: "web",
+		Labels:   map[string]string{"team": "core"},
+		Limits: &Limits{
+			Burst: 10,
+			RPS:   100,
+		},
+		Logger: defaultLogger(),
+		Mascot: &Animal{
+			Color:   "red",
+			Surname: "n/a",
+		},
+		Mode:  "debug",
+		Peers: []string{"10.0.0.1", "10.0.0.2"},
+		Pool: Pool{
+			Idle:    5 * time.Minute,
+			Metrics: &Metrics{Namespace: "server"},
+			Size:    10,
+		},
+		Port: 8080,
+		Retries: func() *int {
+			var v int = 3
+			return &v
+		}(),
+		Started: time.Now(),
+		Timeout: 90 * time.Second,
+		Workers: runtime.NumCPU() * 2,
+	}
+}
+
+func (o *Server) SetDefaults() {
+	if o.Backoff == 0 {
+		o.Backoff = time.Second * 5
+	}
+	if !o.Compress {
+		o.Compress = true
+	}
+	if o.Host == "" {
+		o.Host = "localhost"
+	}
+	if o.Hostname == "" {
+		o.Hostname = "web"
+	}
+	if o.Labels == nil {
+		o.Labels = map[string]string{"team": "core"}
+	}
+	if o.Limits == nil {
+		o.Limits = &Limits{
+			Burst: 10,
+			RPS:   100,
+		}
+	}
+	if o.Logger == nil {
+		o.Logger = defaultLogger()
+	}
+	if o.Mascot == nil {
+		o.Mascot = &Animal{
+			Color:   "red",
+			Surname: "n/a",
+		}
+	}
+	if o.Mode == "" {
+		o.Mode = "debug"
+	}
+	if o.Peers == nil {
+		o.Peers = []string{"10.0.0.1", "10.0.0.2"}
+	}
+	if o.Pool.Size == 0 {
+		o.Pool.Size = 10
+	}
+	if o.Pool.Idle == 0 {
+		o.Pool.Idle = 5 * time.Minute
+	}
+	if o.Pool.Metrics == nil {
+		o.Pool.Metrics = &Metrics{Namespace: "server"}
+	}
+	if o.Port == 0 {
+		o.Port = 8080
+	}
+	if o.Retries == nil {
+		o.Retries = func() *int {
+			var v int = 3
+			return &v
+		}()
+	}
+	if o.Started == (time.Time{}) {
+		o.Started = time.Now()
+	}
+	if o.Timeout == 0 {
+		o.Timeout = 90 * time.Second
+	}
+	if o.Workers == 0 {
+		o.Workers = runtime.NumCPU() * 2
+	}
+}
+
+func ServerDefaults() map[string]interface{} {
+	d := DefaultServer()
+	return map[string]interface{}{
+		"Backoff":  d.Backoff,
+		"Compress": d.Compress,
+		"Host":     d.Host,
+		"Hostname": d.Hostname,
+		"Labels":   d.Labels,
+		"Limits":   d.Limits,
+		"Logger":   d.Logger,
+		"Mascot":   d.Mascot,
+		"Mode":     d.Mode,
+		"Peers":    d.Peers,
+		"Pool":     d.Pool,
+		"Port":     d.Port,
+		"Retries":  d.Retries,
+		"Started":  d.Started,
+		"Timeout":  d.Timeout,
+		"Workers":  d.Workers,
+	}
+}
+
+func (o *Server) UnmarshalJSON(data []byte) error {
+	type plain Server
+
+	v := plain{
+		Backoff:  time.Second * 5,
+		Compress: true,
+		Host:     "localhost",
+		Hostname: "web",
+		Labels:   map[string]string{"team": "core"},
+		Limits: &Limits{
+			Burst: 10,
+			RPS:   100,
+		},
+		Logger: defaultLogger(),
+		Mascot: &Animal{
+			Color:   "red",
+			Surname: "n/a",
+		},
+		Mode:  "debug",
+		Peers: []string{"10.0.0.1", "10.0.0.2"},
+		Pool: Pool{
+			Idle:    5 * time.Minute,
+			Metrics: &Metrics{Namespace: "server"},
+			Size:    10,
+		},
+		Port: 8080,
+		Retries: func() *int {
+			var v int = 3
+			return &v
+		}(),
+		Started: time.Now(),
+		Timeout: 90 * time.Second,
+		Workers: runtime.NumCPU() * 2,
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*o = Server(v)
+	return nil
+}
+
+func RegisterServerFlags(fs *flag.FlagSet, prefix string) func() []ServerOption {
+	backoffFlag := fs.Duration(prefix+"backoff", time.Second*5, "")
+	basicAuthFlag := fs.String(prefix+"basic-auth", "", "")
+	compressFlag := fs.Bool(prefix+"compress", true, "")
+	hostFlag := fs.String(prefix+"host", "localhost", "Host is the address to listen on.")
+	hostnameFlag := fs.String(prefix+"hostname", "web", "")
+	insecureFlag := fs.Bool(prefix+"insecure", false, "Deprecated: TLS certificates are always verified now.")
+	modeFlag := fs.String(prefix+"mode", "debug", "")
+	portFlag := fs.Int(prefix+"listen-port", 8080, "port to listen on")
+	tLSCertFlag := fs.String(prefix+"tls-cert", "", "")
+	tLSKeyFlag := fs.String(prefix+"tls-key", "", "")
+	timeoutFlag := fs.Duration(prefix+"timeout", 90*time.Second, "")
+	workersFlag := fs.Int(prefix+"workers", runtime.NumCPU()*2, "")
+
+	return func() []ServerOption {
+		var opts []ServerOption
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case prefix + "backoff":
+				opts = append(opts, WithBackoff(*backoffFlag))
+			case prefix + "basic-auth":
+				opts = append(opts, WithBasicAuth(*basicAuthFlag))
+			case prefix + "compress":
+				opts = append(opts, WithCompress(*compressFlag))
+			case prefix + "host":
+				opts = append(opts, WithHost(*hostFlag))
+			case prefix + "hostname":
+				opts = append(opts, WithHostname(*hostnameFlag))
+			case prefix + "insecure":
+				opts = append(opts, WithInsecure(*insecureFlag))
+			case prefix + "mode":
+				opts = append(opts, WithMode(*modeFlag))
+			case prefix + "listen-port":
+				opts = append(opts, WithPort(*portFlag))
+			case prefix + "tls-cert":
+				opts = append(opts, WithTLSCert(*tLSCertFlag))
+			case prefix + "tls-key":
+				opts = append(opts, WithTLSKey(*tLSKeyFlag))
+			case prefix + "timeout":
+				opts = append(opts, WithTimeout(*timeoutFlag))
+			case prefix + "workers":
+				opts = append(opts, WithWorkers(*workersFlag))
+			}
+		})
+		return opts
+	}
+}
+
+func ServerOptionsFromMap(m map[string]interface{}) ([]ServerOption, error) {
+	convert := func(v, x interface{}) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, x)
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var opts []ServerOption
+	var errs []string
+
+	for _, k := range keys {
+		v := m[k]
+		switch k {
+		case "Backoff":
+			x, ok := v.(time.Duration)
+			if !ok {
+				var err error
+				if s, isString := v.(string); isString {
+					x, err = time.ParseDuration(s)
+				} else {
+					err = convert(v, &x)
+				}
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as time.Duration: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithBackoff(x))
+		case "BasicAuth":
+			x, ok := v.(string)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as string: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithBasicAuth(x))
+		case "Compress":
+			x, ok := v.(bool)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as bool: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithCompress(x))
+		case "Extra":
+			x, ok := v.(Extra)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as Extra: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithExtra(x))
+		case "Hooks":
+			x, ok := v.([]interface {
+				Run() error
+			})
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as []interface{Run() error}: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithHooks(x))
+		case "Host":
+			x, ok := v.(string)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as string: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithHost(x))
+		case "Hostname":
+			x, ok := v.(string)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as string: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithHostname(x))
+		case "Insecure":
+			x, ok := v.(bool)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as bool: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithInsecure(x))
+		case "Labels":
+			x, ok := v.(map[string]string)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as map[string]string: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithLabels(x))
+		case "Limits":
+			x, ok := v.(*Limits)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as *Limits: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithLimits(x))
+		case "Logger":
+			x, ok := v.(*log.Logger)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as *log.Logger: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithLogger(x))
+		case "Mascot":
+			x, ok := v.(*Animal)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as *Animal: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithMascot(x))
+		case "Mode":
+			x, ok := v.(string)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as string: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithMode(x))
+		case "Peers":
+			x, ok := v.([]string)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as []string: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithPeers(x))
+		case "Pool":
+			x, ok := v.(Pool)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as Pool: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithPool(x))
+		case "Port", "listen_port":
+			x, ok := v.(int)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as int: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithPort(x))
+		case "Retries":
+			x, ok := v.(*int)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as *int: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithRetries(x))
+		case "Started":
+			x, ok := v.(time.Time)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as time.Time: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithStarted(x))
+		case "TLSCert":
+			x, ok := v.(string)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as string: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithTLSCert(x))
+		case "TLSKey":
+			x, ok := v.(string)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as string: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithTLSKey(x))
+		case "Timeout":
+			x, ok := v.(time.Duration)
+			if !ok {
+				var err error
+				if s, isString := v.(string); isString {
+					x, err = time.ParseDuration(s)
+				} else {
+					err = convert(v, &x)
+				}
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as time.Duration: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithTimeout(x))
+		case "Token":
+			x, ok := v.(string)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as string: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithToken(x))
+		case "Workers":
+			x, ok := v.(int)
+			if !ok {
+				if err := convert(v, &x); err != nil {
+					errs = append(errs, fmt.Sprintf("%s: can't use %T as int: %v", k, v, err))
+					continue
+				}
+			}
+			opts = append(opts, WithWorkers(x))
+		default:
+			errs = append(errs, fmt.Sprintf("unknown key %q", k))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid Server options: %s", strings.Join(errs, "; "))
+	}
+	return opts, nil
+}
+
+func FromServer(v Server) ServerOption {
+	return func(o *Server) {
+		for _, opt := range ServerToOptions(&v) {
+			opt(o)
+		}
+	}
+}
+
+func ServerToOptions(v *Server) []ServerOption {
+	var opts []ServerOption
+	if v.Backoff != 0 {
+		opts = append(opts, WithBackoff(v.Backoff))
+	}
+	if v.BasicAuth != "" {
+		opts = append(opts, WithBasicAuth(v.BasicAuth))
+	}
+	if v.Compress {
+		opts = append(opts, WithCompress(v.Compress))
+	}
+	if v.Extra != nil {
+		opts = append(opts, WithExtra(v.Extra))
+	}
+	if v.Hooks != nil {
+		opts = append(opts, WithHooks(v.Hooks))
+	}
+	if v.Host != "" {
+		opts = append(opts, WithHost(v.Host))
+	}
+	if v.Hostname != "" {
+		opts = append(opts, WithHostname(v.Hostname))
+	}
+	if v.Insecure {
+		opts = append(opts, WithInsecure(v.Insecure))
+	}
+	if v.Labels != nil {
+		opts = append(opts, WithLabels(v.Labels))
+	}
+	if v.Limits != nil {
+		opts = append(opts, WithLimits(v.Limits))
+	}
+	if v.Logger != nil {
+		opts = append(opts, WithLogger(v.Logger))
+	}
+	if v.Mascot != nil {
+		opts = append(opts, WithMascot(v.Mascot))
+	}
+	if v.Mode != "" {
+		opts = append(opts, WithMode(v.Mode))
+	}
+	if v.Peers != nil {
+		opts = append(opts, WithPeers(v.Peers))
+	}
+	if v.Pool != (Pool{}) {
+		opts = append(opts, WithPool(v.Pool))
+	}
+	if v.Port != 0 {
+		opts = append(opts, WithPort(v.Port))
+	}
+	if v.Retries != nil {
+		opts = append(opts, WithRetries(v.Retries))
+	}
+	if v.Started != (time.Time{}) {
+		opts = append(opts, WithStarted(v.Started))
+	}
+	if v.TLSCert != "" {
+		opts = append(opts, WithTLSCert(v.TLSCert))
+	}
+	if v.TLSKey != "" {
+		opts = append(opts, WithTLSKey(v.TLSKey))
+	}
+	if v.Timeout != 0 {
+		opts = append(opts, WithTimeout(v.Timeout))
+	}
+	if v.Token != "" {
+		opts = append(opts, WithToken(v.Token))
+	}
+	if v.Workers != 0 {
+		opts = append(opts, WithWorkers(v.Workers))
+	}
+	return opts
+}
+
+func WithBackoff(x time.Duration) ServerOption {
+	return func(o *Server) {
+		o.Backoff = x
+	}
+}
+
+func ResetBackoff() ServerOption {
+	return func(o *Server) {
+		o.Backoff = time.Second * 5
+	}
+}
+
+func WithBasicAuth(x string) ServerOption {
+	return func(o *Server) {
+		o.BasicAuth = x
+		serverApplied.mark(o, "BasicAuth", true)
+	}
+}
+
+func ResetBasicAuth() ServerOption {
+	return func(o *Server) {
+		var zero string
+		o.BasicAuth = zero
+		serverApplied.mark(o, "BasicAuth", false)
+	}
+}
+
+func WithCompress(x bool) ServerOption {
+	return func(o *Server) {
+		o.Compress = x
+	}
+}
+
+func ResetCompress() ServerOption {
+	return func(o *Server) {
+		o.Compress = true
+	}
+}
+
+func WithExtra(x Extra) ServerOption {
+	return func(o *Server) {
+		o.Extra = x
+	}
+}
+
+func ResetExtra() ServerOption {
+	return func(o *Server) {
+		var zero Extra
+		o.Extra = zero
+	}
+}
+
+func WithHooks(x []interface {
+	Run() error
+}) ServerOption {
+	return func(o *Server) {
+		o.Hooks = x
+	}
+}
+
+func ResetHooks() ServerOption {
+	return func(o *Server) {
+		var zero []interface {
+			Run() error
+		}
+		o.Hooks = zero
+	}
+}
+
+func WithHost(x string) ServerOption {
+	return func(o *Server) {
+		o.Host = x
+	}
+}
+
+func ResetHost() ServerOption {
+	return func(o *Server) {
+		o.Host = "localhost"
+	}
+}
+
+func WithHostname(x string) ServerOption {
+	return func(o *Server) {
+		o.Hostname = x
+	}
+}
+
+// Deprecated: Use WithHostname instead.
+func WithHostName(x string) ServerOption {
+	return WithHostname(x)
+}
+
+func ResetHostname() ServerOption {
+	return func(o *Server) {
+		o.Hostname = "web"
+	}
+}
+
+// Deprecated: TLS certificates are always verified now.
+func WithInsecure(x bool) ServerOption {
+	return func(o *Server) {
+		o.Insecure = x
+	}
+}
+
+// Deprecated: TLS certificates are always verified now.
+func ResetInsecure() ServerOption {
+	return func(o *Server) {
+		var zero bool
+		o.Insecure = zero
+	}
+}
+
+func WithLabels(x map[string]string) ServerOption {
+	return func(o *Server) {
+		o.Labels = x
+	}
+}
+
+func ResetLabels() ServerOption {
+	return func(o *Server) {
+		o.Labels = map[string]string{"team": "core"}
+	}
+}
+
+func WithLimits(x *Limits) ServerOption {
+	return func(o *Server) {
+		o.Limits = x
+	}
+}
+
+func ResetLimits() ServerOption {
+	return func(o *Server) {
+		o.Limits = &Limits{
+			Burst: 10,
+			RPS:   100,
+		}
+	}
+}
+
+func WithLogger(x *log.Logger) ServerOption {
+	return func(o *Server) {
+		o.Logger = x
+	}
+}
+
+func ResetLogger() ServerOption {
+	return func(o *Server) {
+		o.Logger = defaultLogger()
+	}
+}
+
+func WithMascot(x *Animal) ServerOption {
+	return func(o *Server) {
+		o.Mascot = x
+	}
+}
+
+func ResetMascot() ServerOption {
+	return func(o *Server) {
+		o.Mascot = &Animal{
+			Color:   "red",
+			Surname: "n/a",
+		}
+	}
+}
+
+func WithMode(x string) ServerOption {
+	return func(o *Server) {
+		o.Mode = x
+	}
+}
+
+func ResetMode() ServerOption {
+	return func(o *Server) {
+		o.Mode = "debug"
+	}
+}
+
+func WithPeers(x []string) ServerOption {
+	return func(o *Server) {
+		o.Peers = x
+	}
+}
+
+func ResetPeers() ServerOption {
+	return func(o *Server) {
+		o.Peers = []string{"10.0.0.1", "10.0.0.2"}
+	}
+}
+
+func WithPool(x Pool) ServerOption {
+	return func(o *Server) {
+		o.Pool = x
+	}
+}
+
+func ResetPool() ServerOption {
+	return func(o *Server) {
+		o.Pool = Pool{
+			Idle:    5 * time.Minute,
+			Metrics: &Metrics{Namespace: "server"},
+			Size:    10,
+		}
+	}
+}
+
+func WithPort(x int) ServerOption {
+	return func(o *Server) {
+		o.Port = x
+	}
+}
+
+func ResetPort() ServerOption {
+	return func(o *Server) {
+		o.Port = 8080
+	}
+}
+
+func WithRetries(x *int) ServerOption {
+	return func(o *Server) {
+		o.Retries = x
+	}
+}
+
+func ResetRetries() ServerOption {
+	return func(o *Server) {
+		o.Retries = func() *int {
+			var v int = 3
+			return &v
+		}()
+	}
+}
+
+func WithStarted(x time.Time) ServerOption {
+	return func(o *Server) {
+		o.Started = x
+	}
+}
+
+func ResetStarted() ServerOption {
+	return func(o *Server) {
+		o.Started = time.Now()
+	}
+}
+
+func WithTLSCert(x string) ServerOption {
+	return func(o *Server) {
+		o.TLSCert = x
+		serverApplied.mark(o, "TLSCert", true)
+	}
+}
+
+func ResetTLSCert() ServerOption {
+	return func(o *Server) {
+		var zero string
+		o.TLSCert = zero
+		serverApplied.mark(o, "TLSCert", false)
+	}
+}
+
+func WithTLSKey(x string) ServerOption {
+	return func(o *Server) {
+		o.TLSKey = x
+		serverApplied.mark(o, "TLSKey", true)
+	}
+}
+
+func ResetTLSKey() ServerOption {
+	return func(o *Server) {
+		var zero string
+		o.TLSKey = zero
+		serverApplied.mark(o, "TLSKey", false)
+	}
+}
+
+func WithTimeout(x time.Duration) ServerOption {
+	return func(o *Server) {
+		o.Timeout = x
+	}
+}
+
+func ResetTimeout() ServerOption {
+	return func(o *Server) {
+		o.Timeout = 90 * time.Second
+	}
+}
+
+func WithToken(x string) ServerOption {
+	return func(o *Server) {
+		o.Token = x
+		serverApplied.mark(o, "Token", true)
+	}
+}
+
+func ResetToken() ServerOption {
+	return func(o *Server) {
+		var zero string
+		o.Token = zero
+		serverApplied.mark(o, "Token", false)
+	}
+}
+
+func WithWorkers(x int) ServerOption {
+	return func(o *Server) {
+		o.Workers = x
+	}
+}
+
+func ResetWorkers() ServerOption {
+	return func(o *Server) {
+		o.Workers = runtime.NumCPU() * 2
+	}
+}
+
+var serverHostnamePattern = regexp.MustCompile("^[a-z][a-z0-9-]*$")
+
+func (o *Server) Validate() error {
+	var errs []string
+
+	if len(o.Host) == 0 {
+		errs = append(errs, "Host (WithHost): must not be empty")
+	}
+	if !serverHostnamePattern.MatchString(o.Hostname) {
+		errs = append(errs, fmt.Sprintf("Hostname (WithHostname): %q does not match %q", o.Hostname, "^[a-z][a-z0-9-]*$"))
+	}
+	switch o.Mode {
+	case "debug", "release", "test":
+	default:
+		errs = append(errs, fmt.Sprintf("Mode (WithMode): %q is not one of %s", o.Mode, "debug release test"))
+	}
+	if o.Port < 1 {
+		errs = append(errs, fmt.Sprintf("Port (WithPort): %v is less than the minimum of %s", o.Port, "1"))
+	}
+	if o.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("Port (WithPort): %v is greater than the maximum of %s", o.Port, "65535"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid Server: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	. "github.com/dave/jennifer/jen"
+)
+
+// fullPathQualifier matches a qualified identifier using a full import path
+// rather than a package name, e.g. net/http.DefaultClient. The last element of
+// the path can't have a dot, other than for a major version like yaml.v2,
+// since we couldn't tell where the path stops otherwise.
+var fullPathQualifier = regexp.MustCompile(`([\w.~-]+(?:/[\w~-]+)*/[\w~-]+(?:\.v\d+)?)\.([A-Za-z_]\w*)`)
+
+// exprCode parses a default as a Go expression, qualifying its package
+// references with their import paths so the generated file imports whatever
+// the expression needs, even if the struct's own file doesn't.
+//
+// A qualifier is resolved against the imports of the file at pos first, then
+// taken as a full import path, e.g. both time.Second and net/http.NoBody work
+// without importing anything. The expression is type-checked against the type
+// either way, where it's at pos if every qualifier came from the file's
+// imports, or else in the package's scope along with the packages it names.
+//
+// Defaults of structs from other packages are resolved in their own package,
+// qualifying whatever they use from it, e.g. DefaultSize becomes
+// conf.DefaultSize.
+func exprCode(typ types.Type, value string, pos token.Pos) (Code, error) {
+	code, tv, err := resolveExpr(value, pos)
+	if err != nil {
+		return nil, err
+	}
+	if typ != nil && !types.AssignableTo(tv.Type, typ) {
+		return nil, fmt.Errorf("%q is %s, which can't be used as %s", value, tv.Type, typ)
+	}
+	return code, nil
+}
+
+// resolveExpr parses and type-checks a default as a Go expression, returning
+// its code along with its type and value, see exprCode.
+func resolveExpr(value string, pos token.Pos) (Code, types.TypeAndValue, error) {
+	p := pkgAt(pos)
+	if p == nil {
+		return nil, types.TypeAndValue{}, fmt.Errorf("can't tell which package %q is from", value)
+	}
+	foreign := p != pkg.Types
+
+	src, paths := replaceFullPaths(value)
+
+	fset := token.NewFileSet()
+	expr, err := parser.ParseExprFrom(fset, "", src, 0)
+	if err != nil {
+		return nil, types.TypeAndValue{}, fmt.Errorf("%q isn't a valid expression: %v", value, err)
+	}
+
+	type qualified struct {
+		start, end int
+		code       Code
+	}
+	quals := []qualified{}
+	qualify := func(n ast.Node, code Code) {
+		quals = append(quals, qualified{
+			start: fset.Position(n.Pos()).Offset,
+			end:   fset.Position(n.End()).Offset,
+			code:  code,
+		})
+	}
+
+	atPos := len(paths) == 0 && !foreign
+	file := fileOf(pos)
+
+	// the packages the expression names, by the names it uses for them
+	imports := map[string]string{}
+
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Ident:
+			// another package's identifiers have to be qualified with it
+			if foreign && p.Scope().Lookup(n.Name) != nil {
+				if !n.IsExported() {
+					err = fmt.Errorf("%s of %s isn't exported", n.Name, p.Path())
+				}
+				qualify(n, Qual(p.Path(), n.Name))
+			}
+			return false
+		case *ast.SelectorExpr:
+			x, ok := n.X.(*ast.Ident)
+			if !ok {
+				return true
+			}
+
+			importPath, ok := paths[x.Name]
+			if !ok {
+				importPath, ok = importPathOf(file, x.Name)
+			}
+			if !ok && foreign {
+				importPath, ok = importNamed(p, x.Name)
+			}
+			if !ok {
+				if p.Scope().Lookup(x.Name) != nil || types.Universe.Lookup(x.Name) != nil {
+					// a field or method of something, rather than a
+					// package
+					ast.Inspect(n.X, visit)
+					return false
+				}
+				// not one of ours, so it had better be a package
+				importPath = x.Name
+				atPos = false
+			}
+			imports[x.Name] = importPath
+
+			qualify(n, Qual(importPath, n.Sel.Name))
+			return false
+		}
+		return true
+	}
+	ast.Inspect(expr, visit)
+	if err != nil {
+		return nil, types.TypeAndValue{}, fmt.Errorf("%q can't be used here: %v", value, err)
+	}
+
+	var tv types.TypeAndValue
+	if atPos {
+		tv, err = types.Eval(pkg.Fset, pkg.Types, pos, value)
+	} else {
+		tv, err = evalWithImports(p, src, imports)
+	}
+	if err != nil {
+		return nil, types.TypeAndValue{}, fmt.Errorf("%q isn't a valid expression: %v", value, err)
+	}
+
+	// splice the qualified identifiers into the rest of the expression
+	sort.Slice(quals, func(i, j int) bool { return quals[i].start < quals[j].start })
+
+	out := Null()
+	last := 0
+	for _, q := range quals {
+		out.Op(src[last:q.start]).Add(q.code)
+		last = q.end
+	}
+	out.Op(src[last:])
+
+	return out, tv, nil
+}
+
+// replaceFullPaths replaces the full import paths qualifying identifiers in
+// the expression with placeholders, returning the paths by placeholder. A path
+// only counts if it can be imported and exports the identifier, and if it
+// doesn't follow an operand, so divisions like time.Hour/time.Millisecond are
+// left alone.
+func replaceFullPaths(value string) (string, map[string]string) {
+	paths := map[string]string{}
+	out := ""
+	last := 0
+
+	for _, m := range fullPathQualifier.FindAllStringSubmatchIndex(value, -1) {
+		importPath, name := value[m[2]:m[3]], value[m[4]:m[5]]
+		if followsOperand(value[:m[0]]) || !exportsName(importPath, name) {
+			continue
+		}
+
+		placeholder := fmt.Sprintf("funcopgenImport%d", len(paths))
+		paths[placeholder] = importPath
+		out += value[last:m[0]] + placeholder + "." + name
+		last = m[1]
+	}
+
+	return out + value[last:], paths
+}
+
+// followsOperand returns whether the expression before ends in an operand,
+// i.e. an identifier, a number, or something closed by a ) or ].
+func followsOperand(before string) bool {
+	before = strings.TrimRight(before, " \t")
+	if before == "" {
+		return false
+	}
+
+	r, _ := utf8.DecodeLastRuneInString(before)
+	return r == '_' || r == ')' || r == ']' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// exportsName returns whether the package at the import path exports the name.
+func exportsName(importPath, name string) bool {
+	if !token.IsExported(name) {
+		return false
+	}
+
+	imported, err := sourceImporter.Import(importPath)
+	return err == nil && imported.Scope().Lookup(name) != nil
+}
+
+// evalWithImports type-checks an expression in the scope of the package, along
+// with the packages it names by the names it uses for them, since they might
+// not be imported by the package at all.
+func evalWithImports(p *types.Package, src string, imports map[string]string) (types.TypeAndValue, error) {
+	evalPkg := types.NewPackage(p.Path(), p.Name())
+	for _, name := range p.Scope().Names() {
+		evalPkg.Scope().Insert(p.Scope().Lookup(name))
+	}
+
+	for name, importPath := range imports {
+		imported, err := sourceImporter.Import(importPath)
+		if err != nil {
+			return types.TypeAndValue{}, fmt.Errorf("can't find package %q", importPath)
+		}
+		evalPkg.Scope().Insert(types.NewPkgName(token.NoPos, evalPkg, name, imported))
+	}
+
+	return types.Eval(token.NewFileSet(), evalPkg, token.NoPos, src)
+}
+
+// fileOf finds the package's file containing pos.
+func fileOf(pos token.Pos) *ast.File {
+	for _, file := range pkg.Syntax {
+		if pkg.Fset.File(file.Pos()) == pkg.Fset.File(pos) {
+			return file
+		}
+	}
+	return nil
+}
+
+// pkgAt returns the package declaring what's at pos, which is ours unless it's
+// a field of a struct from another package, or nil if it can't be found.
+func pkgAt(pos token.Pos) *types.Package {
+	if fileOf(pos) != nil {
+		return pkg.Types
+	}
+
+	file := pkg.Fset.File(pos)
+	if file == nil {
+		return nil
+	}
+	dir := filepath.Dir(file.Name())
+
+	// packages are one to a directory, so any of their objects will do
+	seen := map[*types.Package]bool{}
+	var find func(p *types.Package) *types.Package
+	find = func(p *types.Package) *types.Package {
+		for _, imported := range p.Imports() {
+			if seen[imported] {
+				continue
+			}
+			seen[imported] = true
+
+			for _, name := range imported.Scope().Names() {
+				if f := pkg.Fset.File(imported.Scope().Lookup(name).Pos()); f != nil {
+					if filepath.Dir(f.Name()) == dir {
+						return imported
+					}
+					break
+				}
+			}
+
+			if found := find(imported); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+
+	return find(pkg.Types)
+}
+
+// importNamed returns the path of the package imported by p under the given
+// name, going by the names of the packages themselves, since the files of p
+// aren't around to say otherwise.
+func importNamed(p *types.Package, name string) (string, bool) {
+	for _, imported := range p.Imports() {
+		if imported.Name() == name {
+			return imported.Path(), true
+		}
+	}
+	return "", false
+}
+
+// importPathOf returns the path of the package the file imports under the
+// given name, or false if it doesn't import one.
+func importPathOf(file *ast.File, name string) (string, bool) {
+	if file == nil {
+		return "", false
+	}
+
+	for _, spec := range file.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+
+		importName := path.Base(importPath)
+		if spec.Name != nil {
+			importName = spec.Name.Name
+		} else if obj, ok := pkg.TypesInfo.Implicits[spec].(*types.PkgName); ok {
+			importName = obj.Imported().Name()
+		}
+
+		if importName == name {
+			return importPath, true
+		}
+	}
+
+	return "", false
+}
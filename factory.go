@@ -0,0 +1,59 @@
+package main
+
+import (
+	. "github.com/dave/jennifer/jen"
+)
+
+// factoryFunc generates the factory for the type, e.g. NewAnimal, which starts
+// from the fields' defaults, reads any fields from the environment over them,
+// and then applies the given options over those.
+//
+// If the fields have any validation rules or the options have constraints,
+// the factory checks them and so returns an error too, as it does if it has
+// environment variables to parse.
+func factoryFunc(t *target) *Statement {
+	results := Op("*").Id(t.Name)
+	if t.needsErr() {
+		results = Params(Op("*").Id(t.Name), Error())
+	}
+
+	return Func().Id("New" + t.Name).Params(Id("opts").Op("...").Id(t.Option)).Add(results).BlockFunc(func(g *Group) {
+		g.Id("o").Op(":=").Op("&").Id(t.Name).Values(DictFunc(t.setDefaults))
+		g.Line()
+
+		if len(t.Envs) > 0 {
+			envReads(g, t)
+			g.Line()
+		}
+
+		applyOpts := For(Id("_, opt").Op(":=").Range().Id("opts")).Block(
+			Id("opt").Call(Id("o")),
+		)
+
+		if len(t.Constraints) > 0 {
+			// untrack even if an option panics, or the struct would be
+			// tracked forever
+			g.Id("applied").Op(":=").Id(trackerVar(t)).Dot("track").Call(Id("o"))
+			g.Defer().Id(trackerVar(t)).Dot("untrack").Call(Id("o"))
+			g.Add(applyOpts)
+			g.Line()
+			constraintChecks(g, t)
+		} else {
+			g.Add(applyOpts)
+		}
+		g.Line()
+
+		if t.Validate != nil {
+			g.If(Err().Op(":=").Id("o").Dot("Validate").Call(), Err().Op("!=").Nil()).Block(
+				Return(Nil(), Err()),
+			)
+			g.Line()
+		}
+
+		if t.needsErr() {
+			g.Return(Id("o"), Nil())
+		} else {
+			g.Return(Id("o"))
+		}
+	})
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"go/types"
+	"strings"
+	"unicode"
+
+	. "github.com/dave/jennifer/jen"
+)
+
+// flagFuncs maps the underlying types we can declare flags for to the FlagSet
+// methods declaring them. time.Duration is handled on its own since it's an
+// int64 underneath.
+var flagFuncs = map[types.BasicKind]string{
+	types.String:  "String",
+	types.Bool:    "Bool",
+	types.Int:     "Int",
+	types.Int64:   "Int64",
+	types.Uint:    "Uint",
+	types.Uint64:  "Uint64",
+	types.Float64: "Float64",
+}
+
+// sizedKinds are the numbers the flag package has no flags for, which get a
+// flag.Value of our own checking that they fit instead, see addSizedFlag.
+var sizedKinds = map[types.BasicKind]bool{
+	types.Int8:    true,
+	types.Int16:   true,
+	types.Int32:   true,
+	types.Uint8:   true,
+	types.Uint16:  true,
+	types.Uint32:  true,
+	types.Float32: true,
+}
+
+// flagFunc returns the FlagSet method declaring a flag for the type, and the
+// type the flag's value has, or false if there's no such method.
+func flagFunc(typ types.Type) (string, types.Type, bool) {
+	if typ == nil {
+		return "", nil, false
+	}
+	if isDuration(typ) {
+		return "Duration", typ, true
+	}
+
+	basic, ok := typ.Underlying().(*types.Basic)
+	if !ok {
+		return "", nil, false
+	}
+	if sizedKinds[basic.Kind()] {
+		return "Var", types.Typ[basic.Kind()], true
+	}
+	method, ok := flagFuncs[basic.Kind()]
+	return method, types.Typ[basic.Kind()], ok
+}
+
+// flagName returns the name of the field's flag, from its `flag` tag or else
+// its kebab-cased name, or false if the tag says to skip it with "-".
+func flagName(field string, data *FieldData) (string, bool) {
+	if tag, _ := data.Tags.Get("flag"); tag != nil && tag.Name != "" {
+		return tag.Name, tag.Name != "-"
+	}
+	return kebabCase(field), true
+}
+
+// kebabCase turns a field's name into a flag's, keeping acronyms together,
+// e.g. TLSCert into tls-cert.
+func kebabCase(s string) string {
+	runes := []rune(s)
+	b := strings.Builder{}
+
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := !unicode.IsUpper(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || nextLower {
+				b.WriteRune('-')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+
+	return b.String()
+}
+
+// flagUsage returns the usage of the field's flag from its doc comment.
+func flagUsage(data *FieldData) string {
+	usage := fieldDoc(data)
+
+	if notice, ok := findDeprecation(data); ok {
+		usage = strings.TrimSpace(usage + " Deprecated: " + notice)
+	}
+
+	return usage
+}
+
+// flagField is a field of the type we declare a flag for.
+type flagField struct {
+	field, name, optionFunc string
+	typ, flagType           types.Type
+}
+
+// sized returns whether the flag is declared with a sized flag, see
+// addSizedFlag.
+func (f flagField) sized() bool {
+	basic, ok := f.flagType.(*types.Basic)
+	return ok && sizedKinds[basic.Kind()]
+}
+
+// findFlagFields returns the fields of the type we can declare flags for,
+// exiting if any of them have the same name.
+func findFlagFields(t *target) []flagField {
+	fields := []flagField{}
+	names := map[string]string{}
+
+	for _, field := range t.Keys {
+		data := t.Fields[field]
+
+		optionFunc, ok := t.optionFuncName(field)
+		if !ok {
+			continue
+		}
+		name, ok := flagName(field, data)
+		if !ok {
+			continue
+		}
+		_, flagType, ok := flagFunc(data.GoType)
+		if !ok {
+			continue
+		}
+
+		if other, ok := names[name]; ok {
+			fieldFatalf(data, "flag %q of %s is already used by %s", name, field, other)
+		}
+		names[name] = field
+
+		fields = append(fields, flagField{field, name, optionFunc, data.GoType, flagType})
+	}
+
+	return fields
+}
+
+// sizedFlagType is the name of the flag.Value for the type's sized flags.
+func sizedFlagType(t *target) string {
+	return lowerFirst(t.Name) + "SizedFlag"
+}
+
+// sizedFlagValue returns which of the sized flag's values holds a number of
+// the given kind, and its type. The flag's kind is the value's name too.
+func sizedFlagValue(kind *types.Basic) (string, types.Type) {
+	switch {
+	case kind.Info()&types.IsFloat != 0:
+		return "f", types.Typ[types.Float64]
+	case kind.Info()&types.IsUnsigned != 0:
+		return "u", types.Typ[types.Uint64]
+	}
+	return "i", types.Typ[types.Int64]
+}
+
+// addSizedFlag adds the flag.Value for the type's fields of numbers the flag
+// package has no flags for, e.g. uint16 or float32, if it has any. They're
+// parsed as 64 bit numbers that have to fit in the field's size, so something
+// like -port=70000 is an error rather than wrapping around.
+func addSizedFlag(f *File, t *target) {
+	needed := false
+	for _, field := range findFlagFields(t) {
+		needed = needed || field.sized()
+	}
+	if !needed {
+		return
+	}
+
+	name := sizedFlagType(t)
+	recv := Id("v").Op("*").Id(name)
+
+	f.Commentf("%s is a flag.Value for the numbers of %s the flag package has no flags for, checking they fit in their size.", name, t.Name)
+	f.Type().Id(name).Struct(
+		Id("bits").Int(),
+		Id("kind").Byte(),
+		Id("i").Int64(),
+		Id("u").Uint64(),
+		Id("f").Float64(),
+	)
+	f.Line()
+
+	f.Func().Params(recv.Clone()).Id("String").Params().String().Block(
+		Switch(Id("v").Dot("kind")).Block(
+			Case(LitRune('u')).Block(
+				Return(Qual("strconv", "FormatUint").Call(Id("v").Dot("u"), Lit(10))),
+			),
+			Case(LitRune('f')).Block(
+				Return(Qual("strconv", "FormatFloat").Call(Id("v").Dot("f"), LitRune('g'), Lit(-1), Id("v").Dot("bits"))),
+			),
+		),
+		Return(Qual("strconv", "FormatInt").Call(Id("v").Dot("i"), Lit(10))),
+	)
+	f.Line()
+
+	f.Func().Params(recv.Clone()).Id("Set").Params(Id("s").String()).Error().BlockFunc(func(g *Group) {
+		g.Var().Err().Error()
+		g.Switch(Id("v").Dot("kind")).Block(
+			Case(LitRune('u')).Block(
+				List(Id("v").Dot("u"), Err()).Op("=").Qual("strconv", "ParseUint").Call(Id("s"), Lit(0), Id("v").Dot("bits")),
+			),
+			Case(LitRune('f')).Block(
+				List(Id("v").Dot("f"), Err()).Op("=").Qual("strconv", "ParseFloat").Call(Id("s"), Id("v").Dot("bits")),
+			),
+			Default().Block(
+				List(Id("v").Dot("i"), Err()).Op("=").Qual("strconv", "ParseInt").Call(Id("s"), Lit(0), Id("v").Dot("bits")),
+			),
+		)
+		g.Return(Err())
+	})
+	f.Line()
+}
+
+// registerFlagsFunc generates a function declaring flags on a FlagSet for the
+// type's options, returning a function that turns the flags that were actually
+// set into options once the FlagSet has been parsed, e.g.:
+//
+//	opts := RegisterAnimalFlags(flag.CommandLine, "animal-")
+//	flag.Parse()
+//	a := NewAnimal(opts()...)
+//
+// Fields of types we can't declare flags for are skipped.
+func registerFlagsFunc(t *target) *Statement {
+	fields := findFlagFields(t)
+
+	v := func(f flagField) *Statement { return Id(lowerFirst(f.field) + "Flag") }
+
+	return Func().Id("Register"+t.Name+"Flags").Params(
+		Id("fs").Op("*").Qual("flag", "FlagSet"),
+		Id("prefix").String(),
+	).Func().Params().Index().Id(t.Option).BlockFunc(func(g *Group) {
+		for _, f := range fields {
+			method, _, _ := flagFunc(f.typ)
+
+			if f.sized() {
+				basic := f.flagType.(*types.Basic)
+				kind, kindType := sizedFlagValue(basic)
+
+				value := Dict{
+					Id("bits"): Lit(basicBits(basic)),
+					Id("kind"): LitRune(rune(kind[0])),
+				}
+				if def, ok := t.defaultValue(f.field); ok {
+					value[Id(kind)] = jenTypeOf(kindType).Call(def)
+				}
+
+				g.Add(v(f)).Op(":=").Op("&").Id(sizedFlagType(t)).Values(value)
+				g.Id("fs").Dot(method).Call(
+					v(f),
+					Id("prefix").Op("+").Lit(f.name),
+					Lit(flagUsage(t.Fields[f.field])),
+				)
+				continue
+			}
+
+			value, ok := t.defaultValue(f.field)
+			if !ok {
+				value = zeroLit(f.flagType)
+			} else if !types.Identical(f.typ, f.flagType) {
+				value = jenTypeOf(f.flagType).Call(value)
+			}
+
+			g.Add(v(f)).Op(":=").Id("fs").Dot(method).Call(
+				Id("prefix").Op("+").Lit(f.name),
+				value,
+				Lit(flagUsage(t.Fields[f.field])),
+			)
+		}
+		g.Line()
+
+		g.Return(Func().Params().Index().Id(t.Option).BlockFunc(func(g *Group) {
+			g.Var().Id("opts").Index().Id(t.Option)
+
+			g.Id("fs").Dot("Visit").Call(Func().Params(Id("f").Op("*").Qual("flag", "Flag")).Block(
+				Switch(Id("f").Dot("Name")).BlockFunc(func(g *Group) {
+					for _, f := range fields {
+						x := Op("*").Add(v(f))
+						if f.sized() {
+							kind, _ := sizedFlagValue(f.flagType.(*types.Basic))
+							x = jenTypeOf(f.typ).Call(v(f).Dot(kind))
+						} else if !types.Identical(f.typ, f.flagType) {
+							x = jenTypeOf(f.typ).Call(x)
+						}
+						g.Case(Id("prefix").Op("+").Lit(f.name)).Block(
+							Id("opts").Op("=").Append(Id("opts"), Id(f.optionFunc).Call(x)),
+						)
+					}
+				}),
+			))
+
+			g.Return(Id("opts"))
+		}))
+	})
+}
+
+// zeroLit returns the zero value of a basic type as a literal.
+func zeroLit(typ types.Type) *Statement {
+	if basic, ok := typ.Underlying().(*types.Basic); ok {
+		switch {
+		case basic.Info()&types.IsString != 0:
+			return Lit("")
+		case basic.Info()&types.IsBoolean != 0:
+			return False()
+		}
+	}
+	return Lit(0)
+}
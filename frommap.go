@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"strings"
+
+	. "github.com/dave/jennifer/jen"
+)
+
+// mapKeys returns the keys each of the type's options can be given by in a
+// map, i.e. the field's name, the name from its `json` tag, and any from its
+// `funcop` tag, e.g. `funcop:"key=listen_port"`. It exits if two fields can be
+// given by the same key.
+func mapKeys(t *target) map[string][]string {
+	out := map[string][]string{}
+	owners := map[string]string{}
+
+	for _, field := range t.Keys {
+		data := t.Fields[field]
+		if _, ok := t.optionFuncName(field); !ok {
+			continue
+		}
+
+		keys := []string{field}
+		if tag, _ := data.Tags.Get("json"); tag != nil && tag.Name != "" && tag.Name != "-" {
+			keys = append(keys, tag.Name)
+		}
+		keys = append(keys, strings.Fields(findFuncopTag(data)["key"])...)
+
+		for _, key := range keys {
+			if owner, ok := owners[key]; ok && owner != field {
+				fieldFatalf(data, "key %q of %s is already used by %s", key, field, owner)
+			}
+			if owners[key] == "" {
+				owners[key] = field
+				out[field] = append(out[field], key)
+			}
+		}
+	}
+
+	return out
+}
+
+// optionsFromMapFunc generates a function turning a map, e.g. one decoded from
+// a config file, into the type's options. Values of the field's own type are
+// used as they are, while anything else is converted by a round trip through
+// JSON, so the float64 a JSON number decodes to works for an int field too.
+// Durations can be given as strings like "1m30s" too.
+func optionsFromMapFunc(t *target) *Statement {
+	keys := mapKeys(t)
+
+	return Func().Id(t.Name+"OptionsFromMap").Params(
+		Id("m").Map(String()).Interface(),
+	).Params(Index().Id(t.Option), Error()).BlockFunc(func(g *Group) {
+		g.Id("convert").Op(":=").Func().Params(Id("v"), Id("x").Interface()).Error().Block(
+			List(Id("b"), Err()).Op(":=").Qual("encoding/json", "Marshal").Call(Id("v")),
+			If(Err().Op("!=").Nil()).Block(Return(Err())),
+			Return(Qual("encoding/json", "Unmarshal").Call(Id("b"), Id("x"))),
+		)
+		g.Line()
+
+		// go through the keys in order, so the options come out the same
+		// every time
+		g.Id("keys").Op(":=").Make(Index().String(), Lit(0), Len(Id("m")))
+		g.For(Id("k").Op(":=").Range().Id("m")).Block(
+			Id("keys").Op("=").Append(Id("keys"), Id("k")),
+		)
+		g.Qual("sort", "Strings").Call(Id("keys"))
+		g.Line()
+
+		g.Var().Id("opts").Index().Id(t.Option)
+		g.Var().Id("errs").Index().String()
+		g.Line()
+
+		g.For(List(Id("_"), Id("k")).Op(":=").Range().Id("keys")).Block(
+			Id("v").Op(":=").Id("m").Index(Id("k")),
+			Switch(Id("k")).BlockFunc(func(g *Group) {
+				for _, field := range t.Keys {
+					if len(keys[field]) == 0 {
+						continue
+					}
+					optionFunc, _ := t.optionFuncName(field)
+					typ := t.Fields[field].GoType
+					if typ == nil {
+						continue
+					}
+
+					cases := []Code{}
+					for _, key := range keys[field] {
+						cases = append(cases, Lit(key))
+					}
+
+					fail := Block(
+						Id("errs").Op("=").Append(Id("errs"), Qual("fmt", "Sprintf").Call(
+							Lit(fmt.Sprintf("%%s: can't use %%T as %s: %%v", types.TypeString(typ, types.RelativeTo(pkg.Types)))),
+							Id("k"), Id("v"), Err(),
+						)),
+						Continue(),
+					)
+
+					conversion := If(Err().Op(":=").Id("convert").Call(Id("v"), Op("&").Id("x")), Err().Op("!=").Nil()).Add(fail)
+					if isDuration(typ) {
+						conversion = Var().Err().Error().Line().If(List(Id("s"), Id("isString")).Op(":=").Id("v").Assert(String()), Id("isString")).Block(
+							List(Id("x"), Err()).Op("=").Qual("time", "ParseDuration").Call(Id("s")),
+						).Else().Block(
+							Err().Op("=").Id("convert").Call(Id("v"), Op("&").Id("x")),
+						).Line().If(Err().Op("!=").Nil()).Add(fail)
+					}
+
+					g.Case(cases...).Block(
+						List(Id("x"), Id("ok")).Op(":=").Id("v").Assert(jenTypeOf(typ)),
+						If(Op("!").Id("ok")).Block(conversion),
+						Id("opts").Op("=").Append(Id("opts"), Id(optionFunc).Call(Id("x"))),
+					)
+				}
+				g.Default().Block(
+					Id("errs").Op("=").Append(Id("errs"), Qual("fmt", "Sprintf").Call(Lit("unknown key %q"), Id("k"))),
+				)
+			}),
+		)
+		g.Line()
+
+		g.If(Len(Id("errs")).Op(">").Lit(0)).Block(
+			Return(Nil(), Qual("fmt", "Errorf").Call(Lit("invalid "+t.Name+" options: %s"), Qual("strings", "Join").Call(Id("errs"), Lit("; ")))),
+		)
+		g.Return(Id("opts"), Nil())
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import . "github.com/dave/jennifer/jen"
+
+// toOptionsFuncName is the name of the function turning a struct into options,
+// e.g. ToOptions, or AnimalToOptions with -unique-option.
+func toOptionsFuncName(t *target) string {
+	if t.Config.UniqueOption {
+		return t.Name + "ToOptions"
+	}
+	return "ToOptions"
+}
+
+// toOptionsFunc generates a function turning a struct back into the options
+// building it, one for each of its non-zero fields, e.g. for logging a
+// configuration.
+func toOptionsFunc(t *target) *Statement {
+	return Func().Id(toOptionsFuncName(t)).Params(Id("v").Op("*").Id(t.Name)).Index().Id(t.Option).BlockFunc(func(g *Group) {
+		g.Var().Id("opts").Index().Id(t.Option)
+
+		for _, field := range t.Keys {
+			optionFunc, ok := t.optionFuncName(field)
+			if !ok {
+				continue
+			}
+
+			x := Id("v").Dot(field)
+			g.If(nonZeroCheck(t.Fields[field].GoType, x.Clone())).Block(
+				Id("opts").Op("=").Append(Id("opts"), Id(optionFunc).Call(x.Clone())),
+			)
+		}
+
+		g.Return(Id("opts"))
+	})
+}
+
+// fromFunc generates an option applying the non-zero fields of a struct, e.g.
+// one from a test fixture, as if their options were given one by one.
+func fromFunc(t *target) *Statement {
+	return Func().Id("From" + t.Name).Params(Id("v").Id(t.Name)).Id(t.Option).Block(
+		Return(Func().Params(Id("o").Op("*").Id(t.Name)).Block(
+			For(List(Id("_"), Id("opt")).Op(":=").Range().Id(toOptionsFuncName(t)).Call(Op("&").Id("v"))).Block(
+				Id("opt").Call(Id("o")),
+			),
+		)),
+	)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// generatedMarker matches the first line of every file we generate, following
+// https://golang.org/s/generatedcode so other tools know not to touch them.
+var generatedMarker = regexp.MustCompile(`^Code generated by funcopgen.* DO NOT EDIT\.$`)
+
+// provenance matches the parts of our header saying where a file came from
+// rather than what's in it: the version of funcopgen, and the command and
+// config file it was run with. They're matched in JSON strings too, e.g. the
+// $comment of a schema.
+var provenance = regexp.MustCompile(`(Code generated by funcopgen )\S+(\. DO NOT EDIT)|(Command|Config): .*?(\n|\\n|")`)
+
+// withoutProvenance returns the file without the provenance in its header, so
+// files generated the same way by different versions or commands compare the
+// same, e.g. -type=Animal and a type inferred from a go:generate directive.
+func withoutProvenance(b []byte) []byte {
+	return provenance.ReplaceAll(b, []byte("$1$2$4"))
+}
+
+// legacyHeader started the files generated by older versions of funcopgen,
+// which we still want to recognize, e.g. to prune them.
+const legacyHeader = "This file has been automatically generated. Don't edit it."
+
+// generatedHeader returns the header of a file generated for the types, saying
+// how to generate it again: which version of funcopgen with which flags, where
+// the types came from, and which config file was used.
+func generatedHeader(types []string) string {
+	sources := map[string]bool{}
+	for _, t := range types {
+		if obj := pkg.Types.Scope().Lookup(t); obj != nil {
+			sources[filepath.Base(pkg.Fset.Position(obj.Pos()).Filename)] = true
+		}
+	}
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// the packages and -C only say where we're run, so they're left out
+	// along with the rest of what doesn't change what's generated, so the
+	// header is the same however the package is reached
+	args := []string{"funcopgen"}
+	flags := os.Args[1 : len(os.Args)-len(fs.Args())]
+	for i := 0; i < len(flags); i++ {
+		arg := flags[i]
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if strings.HasPrefix(arg, "-") && (name == "check" || name == "prune") {
+			continue
+		}
+		if strings.HasPrefix(arg, "-") && name == "C" {
+			if !strings.Contains(arg, "=") {
+				i++
+			}
+			continue
+		}
+
+		if strings.ContainsAny(arg, " \t\n\"'`$\\") {
+			arg = strconv.Quote(arg)
+		}
+		args = append(args, arg)
+	}
+
+	header := fmt.Sprintf("Code generated by funcopgen %s. DO NOT EDIT.\n\nCommand: %s\nSource: %s",
+		version(), strings.Join(args, " "), strings.Join(names, ", "))
+	if pkgConfig != nil {
+		rel, err := filepath.Rel(pkgDir(), pkgConfig.Path)
+		if err != nil {
+			rel = pkgConfig.Path
+		}
+		header += "\nConfig: " + filepath.ToSlash(rel)
+	}
+
+	return header
+}
+
+// version returns the version of funcopgen we were built from, which isn't
+// known when it's built from a checkout with local changes.
+func version() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && !strings.HasSuffix(info.Main.Version, "+dirty") {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// isGenerated returns whether the file is one we've generated, going by its
+// header.
+func isGenerated(file *ast.File) bool {
+	if file == nil || len(file.Comments) == 0 || file.Comments[0].Pos() > file.Package {
+		return false
+	}
+
+	text := strings.TrimSpace(file.Comments[0].Text())
+	firstLine := strings.SplitN(text, "\n", 2)[0]
+
+	return generatedMarker.MatchString(firstLine) || text == legacyHeader
+}
+
+// generatedForTypes returns whether the generated file was generated for types
+// named with -type, going by the command in its header. Files from older
+// versions of funcopgen always were, since it was the only way to name them.
+func generatedForTypes(file *ast.File) bool {
+	text := strings.TrimSpace(file.Comments[0].Text())
+	if text == legacyHeader {
+		return true
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "Command: ") {
+			return hasFlag(strings.Fields(line), "type")
+		}
+	}
+	return false
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"go/ast"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 	"unicode"
@@ -25,6 +32,14 @@ type FieldData struct {
 	// representation of it, e.g. something like "string" or
 	// "[]*myQualified.StructType", we can fmt.Sprintf("%#v", blah) it
 	Type *Statement
+
+	// GoType is the go/types representation of the field's type, so we can
+	// check the field's tags against it at generation time. It's nil if the
+	// type couldn't be resolved.
+	GoType types.Type
+
+	// Field is the field's node in the AST, handy for reporting positions.
+	Field *ast.Field
 }
 
 func firstRune(str string) (r rune) {
@@ -38,17 +53,22 @@ func structFieldsToMap(s *ast.StructType) StructFieldMap {
 	out := StructFieldMap{}
 
 	for _, f := range s.Fields.List {
-		jenType := findJenTypeOfField(f)
-
 		data := &FieldData{
-			Type: jenType,
-			Tags: findFieldTags(f),
+			Tags:   findFieldTags(f),
+			GoType: pkg.TypesInfo.TypeOf(f.Type),
+			Field:  f,
 		}
+		if data.GoType != nil && !supportedType(data.GoType) {
+			fieldFatalf(data, "the type %s isn't supported", types.ExprString(f.Type))
+		}
+
+		jenType := findJenTypeOfField(f)
+		data.Type = jenType
 
 		// anonymous field, i.e. an embedded field
-		// and if it's a qualified type, we drop the qualifier
+		// and if it's a qualified type or a pointer, we drop the qualifier
 		if f.Names == nil {
-			typeName := fmt.Sprintf("%#v", jenType)
+			typeName := strings.TrimPrefix(fmt.Sprintf("%#v", jenType), "*")
 			split := strings.Split(typeName, ".")
 			if len(split) == 1 {
 				out[typeName] = data
@@ -85,6 +105,58 @@ func findFieldTags(f *ast.Field) *structtag.Tags {
 	return tag
 }
 
+// funcopKeys are the keys we understand in a field's `funcop` tag.
+var funcopKeys = map[string]bool{
+	"alias":    true,
+	"excludes": true,
+	"init":     true,
+	"key":      true,
+	"requires": true,
+}
+
+// findFuncopTag parses a field's `funcop` tag, e.g.
+// `funcop:"excludes=Token,requires=TLSCert"`, into a map of its keys to their
+// values. Keys without values map to an empty string.
+func findFuncopTag(data *FieldData) map[string]string {
+	out := map[string]string{}
+
+	tag, _ := data.Tags.Get("funcop")
+	if tag == nil {
+		return out
+	}
+
+	for _, part := range append([]string{tag.Name}, tag.Options...) {
+		split := strings.SplitN(part, "=", 2)
+		if !funcopKeys[split[0]] {
+			fieldFatalf(data, "unknown funcop key %q", split[0])
+		}
+		if len(split) == 2 {
+			out[split[0]] = split[1]
+		} else {
+			out[split[0]] = ""
+		}
+	}
+
+	return out
+}
+
+// fieldFatalf reports a problem with a field at the position of its tag, or
+// of the field itself if it has no tag, and exits.
+func fieldFatalf(data *FieldData, format string, args ...interface{}) {
+	pos := data.Field.Pos()
+	if data.Field.Tag != nil {
+		pos = data.Field.Tag.Pos()
+	}
+
+	fatalAt(pos, format, args...)
+}
+
+// fatalAt reports a problem at the given position and exits.
+func fatalAt(pos token.Pos, format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", pkg.Fset.Position(pos), fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
 func findJenTypeOfField(field *ast.Field) *Statement {
 	var f func(e interface{}) *Statement
 
@@ -92,6 +164,11 @@ func findJenTypeOfField(field *ast.Field) *Statement {
 		switch typ := e.(type) {
 		case *ast.InterfaceType:
 			typeName = Interface()
+			if len(typ.Methods.List) > 0 {
+				typeName = jenTypeOf(pkg.TypesInfo.TypeOf(typ))
+			}
+		case *ast.FuncType:
+			typeName = jenTypeOf(pkg.TypesInfo.TypeOf(typ))
 		case *ast.Ident:
 			typeName = Id(typ.Name)
 		case *ast.StarExpr:
@@ -125,21 +202,275 @@ func findJenTypeOfField(field *ast.Field) *Statement {
 	return f(field.Type)
 }
 
+// jenTypeOf is the Jen representation of a go/types type, qualifying named
+// types from other packages with their import paths.
+func jenTypeOf(typ types.Type) *Statement {
+	switch t := typ.(type) {
+	case *types.Basic:
+		return Id(t.Name())
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() == nil || obj.Pkg().Path() == pkg.PkgPath {
+			return Id(obj.Name())
+		}
+		return Qual(obj.Pkg().Path(), obj.Name())
+	case *types.Alias:
+		// aliases are written as they were, e.g. any, unless they can't
+		// be, e.g. an unexported alias from another package
+		obj := t.Obj()
+		switch {
+		case t.TypeArgs().Len() > 0:
+		case obj.Pkg() == nil || obj.Pkg().Path() == pkg.PkgPath:
+			return Id(obj.Name())
+		case obj.Exported():
+			return Qual(obj.Pkg().Path(), obj.Name())
+		}
+		return jenTypeOf(types.Unalias(t))
+	case *types.Pointer:
+		return Op("*").Add(jenTypeOf(t.Elem()))
+	case *types.Slice:
+		return Index().Add(jenTypeOf(t.Elem()))
+	case *types.Array:
+		return Index(Lit(int(t.Len()))).Add(jenTypeOf(t.Elem()))
+	case *types.Map:
+		return Map(jenTypeOf(t.Key())).Add(jenTypeOf(t.Elem()))
+	case *types.Chan:
+		switch t.Dir() {
+		case types.SendOnly:
+			return Chan().Op("<-").Add(jenTypeOf(t.Elem()))
+		case types.RecvOnly:
+			return Op("<-").Chan().Add(jenTypeOf(t.Elem()))
+		}
+		return Chan().Add(jenTypeOf(t.Elem()))
+	case *types.Signature:
+		return Func().Add(jenSignature(t))
+	case *types.Interface:
+		methods := []Code{}
+		for i := 0; i < t.NumEmbeddeds(); i++ {
+			methods = append(methods, jenTypeOf(t.EmbeddedType(i)))
+		}
+		for i := 0; i < t.NumExplicitMethods(); i++ {
+			m := t.ExplicitMethod(i)
+			methods = append(methods, Id(m.Name()).Add(jenSignature(m.Type().(*types.Signature))))
+		}
+		return Interface(methods...)
+	}
+
+	// supportedType should have kept anything else from getting here
+	panic(fmt.Errorf("unhandled case for type %s", typ))
+}
+
+// jenSignature is the Jen representation of a func's params and results,
+// leaving out their names.
+func jenSignature(sig *types.Signature) *Statement {
+	params := []Code{}
+	for i := 0; i < sig.Params().Len(); i++ {
+		typ := sig.Params().At(i).Type()
+		if sig.Variadic() && i == sig.Params().Len()-1 {
+			params = append(params, Op("...").Add(jenTypeOf(typ.(*types.Slice).Elem())))
+			continue
+		}
+		params = append(params, jenTypeOf(typ))
+	}
+
+	results := []Code{}
+	for i := 0; i < sig.Results().Len(); i++ {
+		results = append(results, jenTypeOf(sig.Results().At(i).Type()))
+	}
+
+	s := Params(params...)
+	switch len(results) {
+	case 0:
+	case 1:
+		s.Add(results[0])
+	default:
+		s.Parens(List(results...))
+	}
+	return s
+}
+
+// supportedType returns whether we can write the type out with jenTypeOf,
+// i.e. whether it has no anonymous structs or generics in it.
+func supportedType(typ types.Type) bool {
+	switch t := typ.(type) {
+	case *types.Basic:
+		return true
+	case *types.Named:
+		return t.TypeArgs().Len() == 0
+	case *types.Alias:
+		return supportedType(types.Unalias(t))
+	case *types.Pointer:
+		return supportedType(t.Elem())
+	case *types.Slice:
+		return supportedType(t.Elem())
+	case *types.Array:
+		return supportedType(t.Elem())
+	case *types.Map:
+		return supportedType(t.Key()) && supportedType(t.Elem())
+	case *types.Chan:
+		return supportedType(t.Elem())
+	case *types.Signature:
+		return supportedTuple(t.Params()) && supportedTuple(t.Results())
+	case *types.Interface:
+		for i := 0; i < t.NumEmbeddeds(); i++ {
+			if !supportedType(t.EmbeddedType(i)) {
+				return false
+			}
+		}
+		for i := 0; i < t.NumExplicitMethods(); i++ {
+			if !supportedType(t.ExplicitMethod(i).Type()) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func supportedTuple(tuple *types.Tuple) bool {
+	for i := 0; i < tuple.Len(); i++ {
+		if !supportedType(tuple.At(i).Type()) {
+			return false
+		}
+	}
+	return true
+}
+
 var (
-	fs           = flag.NewFlagSet("funcopgen", flag.ExitOnError)
-	typeNames    = fs.String("type", "", "Comma-delimited list of type names")
-	prefix       = fs.String("prefix", "", "Prefix to attach to functional options, e.g. WithColor, WithName, etc.")
-	factory      = fs.Bool("factory", false, "If present, add a factory function for your type, e.g. NewAnimal(opt ...Option)")
-	unexported   = fs.Bool("unexported", false, "If present, functional options are also generated for unexported fields.")
-	uniqueOption = fs.Bool("unique-option", false,
-		"If present, prepends the type to the Option type, e.g. AnimalOption.\n"+
-			"Handy if generating for several structs within the same package.",
-	)
-
-	pkg *packages.Package
+	fs         = flag.NewFlagSet("funcopgen", flag.ExitOnError)
+	typeNames  = fs.String("type", "", "Comma-delimited list of type names, rather than the ones with a //funcopgen:generate marker")
+	typeRegexp = fs.String("type-regexp", "", "Generate for every struct whose name matches this regular expression, rather than the ones with a marker")
+	all        = fs.Bool("all", false, "If present, generate for every struct in the package, rather than the ones with a marker")
+	check      = fs.Bool("check", false, "If present, check the generated files are up to date instead of writing them, exiting non-zero if they aren't")
+	prune      = fs.Bool("prune", false, "If present, delete stale generated files, e.g. for types that are gone or no longer marked, rather than just reporting them")
+	chdir      = fs.String("C", "", "Change to this directory before doing anything else, like go -C")
+	docs       = fs.String("docs", "", "Markdown file to write a table of your options to, between <!-- funcopgen:begin Animal --> and <!-- funcopgen:end Animal --> if it has them")
+
+	// config holds the rest of the flags, i.e. how to generate options for
+	// types. Only the ones that were actually given matter, since they're
+	// applied on top of the config file, see configFor.
+	config = typeConfig{Output: defaultOutput}
+
+	// pkgConfig is the config file for the package, if it has one
+	pkgConfig *configFile
+
+	// pkgs are the packages we're generating for, while pkg is the one
+	// we're generating for right now
+	pkgs []*packages.Package
+	pkg  *packages.Package
+
+	// sourceImporter imports packages from source for every package we're
+	// generating for, so it only does so once for each
+	sourceImporter types.Importer
+
+	// multiPackage is whether we're generating for several packages at
+	// once, e.g. with ./...
+	multiPackage bool
+
+	// targets are the types we're generating for, by name
+	targets = map[string]*target{}
 )
 
-func init() {
+// target is a struct we're generating functional options for.
+type target struct {
+	// Name is the name of the struct, e.g. Animal
+	Name string
+
+	// Option is the name of the generated option type, e.g. Option, or
+	// AnimalOption if we're using -unique-option
+	Option string
+
+	// Config is how to generate its options
+	Config *typeConfig
+
+	Fields StructFieldMap
+
+	// Keys are the names of the struct's fields, sorted so we can traverse
+	// Fields in a deterministic order
+	Keys []string
+
+	// Validate is the struct's generated Validate method, or nil if none of
+	// its fields have validation rules
+	Validate *Statement
+
+	// Constraints are the constraints between the options of its fields
+	Constraints []constraint
+
+	// Envs are its fields read from the environment by the factory
+	Envs []envVar
+}
+
+// needsErr returns whether the type's factory can fail, in which case it
+// returns an error too.
+func (t *target) needsErr() bool {
+	return t.Validate != nil || len(t.Constraints) > 0 || len(t.Envs) > 0
+}
+
+// tracked returns whether the option for the field has to be tracked when
+// it's applied, i.e. if it's part of any constraint.
+func (t *target) tracked(field string) bool {
+	for _, c := range t.Constraints {
+		if c.Field == field || c.Other == field {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultValue returns the expression for the field's default from its
+// `default` tag, or from the defaults of its own fields if it's a struct, or
+// false if it doesn't have one.
+func (t *target) defaultValue(field string) (Code, bool) {
+	data := t.Fields[field]
+
+	tag, _ := data.Tags.Get("default")
+	if tag == nil {
+		if data.GoType == nil {
+			return nil, false
+		}
+		_, init := findFuncopTag(data)["init"]
+		seen := map[types.Type]bool{}
+		if obj := pkg.Types.Scope().Lookup(t.Name); obj != nil {
+			seen[obj.Type()] = true
+		}
+		return nestedDefault(data.GoType, init, true, seen)
+	}
+
+	// put back any commas structtag split on, e.g. in `default:"a,b"`
+	value, err := defaultCode(data.GoType, tag.Value(), data.Field.Pos())
+	if err != nil {
+		fieldFatalf(data, "bad default for %s: %v", field, err)
+	}
+
+	return value, true
+}
+
+// setDefaults fills the dict with the fields' defaults from their `default`
+// tags, for use in a composite literal of the struct.
+func (t *target) setDefaults(d Dict) {
+	for _, field := range t.Keys {
+		if value, ok := t.defaultValue(field); ok {
+			d[Id(field)] = value
+		}
+	}
+}
+
+// optionFuncName returns the name of the functional option generated for the
+// given field, or false if the field doesn't get one.
+func (t *target) optionFuncName(field string) (string, bool) {
+	if unicode.IsLower(firstRune(field)) {
+		if !t.Config.Unexported {
+			return "", false
+		}
+		return t.Config.Prefix + strings.Title(field), true
+	}
+	return t.Config.Prefix + field, true
+}
+
+// load parses the flags, then loads the packages we're generating for. It's
+// called by main rather than being an init func, so tests don't go through it.
+func load() {
+	config.register(fs)
 	fs.Parse(os.Args[1:])
 
 	fs.Usage = func() {
@@ -147,72 +478,133 @@ func init() {
 		fs.PrintDefaults()
 	}
 
-	if len(*typeNames) == 0 {
-		fs.Usage()
-		os.Exit(1)
+	if *chdir != "" {
+		if err := os.Chdir(*chdir); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	pkgs, err := packages.Load(&packages.Config{
+	// Load every package at once, so whatever they have in common is
+	// only loaded once. Without any patterns, it's the package in the
+	// working directory, as is the case when run from a go:generate
+	// directive.
+	fset := token.NewFileSet()
+	loaded, err := packages.Load(&packages.Config{
 		Mode: packages.NeedName | packages.NeedSyntax | packages.NeedImports,
-	})
+		Fset: fset,
+	}, fs.Args()...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "load: %v\n", err)
 		os.Exit(1)
 	}
 
-	if packages.PrintErrors(pkgs) > 0 {
+	if packages.PrintErrors(loaded) > 0 {
 		os.Exit(1)
 	}
 
-	// Since this tool is meant to be used from a go:generate comment, there
-	// should only ever be one package.
-	if len(pkgs) != 1 {
-		fmt.Fprintf(os.Stderr, "expected only one package!")
-		os.Exit(1)
+	sourceImporter = importer.ForCompiler(fset, "source", nil)
+
+	for _, p := range loaded {
+		if len(p.Syntax) == 0 {
+			continue
+		}
+
+		p.Fset = fset
+		typeCheck(p, sourceImporter)
+		pkgs = append(pkgs, p)
 	}
 
-	pkg = pkgs[0]
+	multiPackage = len(pkgs) > 1
 }
 
-func main() {
-	types := map[string]interface{}{}
-	for _, t := range strings.Split(*typeNames, ",") {
-		types[t] = nil
+// typeCheck fills in the package's type information so we can check field tags
+// against the types of the fields. We do this ourselves rather than asking
+// packages.Load for it, since the loader's sizes don't agree with newer Go
+// toolchains.
+//
+// Type errors are expected, e.g. from code referencing options we haven't
+// generated yet, or from previously generated files that are now stale, so
+// they're ignored. Whatever did resolve is good enough for us.
+//
+// The importer is shared between packages, so their imports are only checked
+// once.
+func typeCheck(p *packages.Package, imp types.Importer) {
+	conf := &types.Config{
+		Importer: imp,
+		Sizes:    types.SizesFor("gc", runtime.GOARCH),
+		Error:    func(error) {},
 	}
 
-	// Find structs
-	structs := map[string]StructFieldMap{}
+	p.TypesInfo = &types.Info{
+		Types:      map[ast.Expr]types.TypeAndValue{},
+		Defs:       map[*ast.Ident]types.Object{},
+		Uses:       map[*ast.Ident]types.Object{},
+		Implicits:  map[ast.Node]types.Object{},
+		Selections: map[*ast.SelectorExpr]*types.Selection{},
+		Scopes:     map[ast.Node]*types.Scope{},
+	}
+	p.TypesSizes = conf.Sizes
+	p.Types, _ = conf.Check(p.PkgPath, p.Fset, p.Syntax, p.TypesInfo)
+}
 
-	for _, file := range pkg.Syntax {
-		for objName, obj := range file.Scope.Objects {
-			if _, ok := types[objName]; !ok {
-				continue
-			}
-			switch obj.Kind {
-			case ast.Typ:
-				switch typ := obj.Decl.(*ast.TypeSpec).Type.(type) {
-				case *ast.StructType:
-					structs[objName] = structFieldsToMap(typ)
-				case *ast.Ident:
-					continue
-				default:
-					continue
-				}
-			case ast.Con:
-				continue
-			default:
-				continue
-			}
+func main() {
+	load()
+
+	ok := true
 
+	for _, p := range pkgs {
+		pkg = p
+		targets = map[string]*target{}
+		pkgConfig = findConfigFile(pkgDir())
+
+		if !generatePackage() {
+			ok = false
 		}
 	}
 
-	for t := range types {
-		fields, ok := structs[t]
-		if !ok {
-			fmt.Fprintf(os.Stderr, "Unknown type %q in %q in package %q\n", t, pkg.Name, pkg.PkgPath)
-			os.Exit(1)
+	if *typeNames != "" {
+		for _, t := range strings.Split(*typeNames, ",") {
+			if !foundTypes[t] {
+				fmt.Fprintf(os.Stderr, "Unknown type %q in any of the packages\n", t)
+				ok = false
+			}
 		}
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// generatePackage generates everything for the types of the current package,
+// returning false if we're only checking and something's out of date.
+func generatePackage() bool {
+	decls := findTypeDecls()
+
+	names := selectTypes(decls)
+	if len(names) == 0 {
+		// plenty of packages have nothing for us when we're given a
+		// bunch of them, and removing the last marker of a package
+		// leaves nothing but stale files to deal with
+		if stale, untouched := staleFiles(nil); multiPackage || len(stale) > 0 {
+			if *check {
+				return checkFiles(nil, stale, untouched)
+			}
+			pruneFiles(stale)
+			return true
+		}
+
+		fmt.Fprintf(os.Stderr, "No types to generate for in %q, use -type or mark them with %s\n", pkg.PkgPath, markerPrefix)
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	// Collect everything about the types up front, since generating one
+	// type might depend on another, e.g. for nested defaults.
+	for _, t := range names {
+		fields := structFieldsToMap(decls[t].Struct)
+		cfg := configFor(decls[t])
 
 		// Sort the fields so we can traverse the map in a deterministic
 		// order as we want the generated code to be the same between
@@ -223,77 +615,212 @@ func main() {
 		}
 		sort.Strings(keys)
 
-		f := NewFile(pkg.Name)
-
-		f.HeaderComment("This file has been automatically generated. Don't edit it.")
-
 		optionName := ""
-		if *uniqueOption {
+		if cfg.UniqueOption {
 			optionName = t
 		}
 		optionName += "Option"
 
-		f.Add(Type().Id(optionName).Func().Params(Op("*").Id(t)), Line())
+		tgt := &target{
+			Name:   t,
+			Option: optionName,
+			Config: cfg,
+			Fields: fields,
+			Keys:   keys,
+		}
 
-		setDefaults := func(d Dict) {
-			for _, field := range keys {
-				tags := fields[field].Tags
+		tgt.Validate = validateFunc(tgt)
 
-				if tag, _ := tags.Get("default"); tag != nil {
-					switch t := fields[field].Type; fmt.Sprintf("%#v", t) {
-					case "string":
-						d[Id(field)] = Lit(tag.Name)
-					default:
-						d[Id(field)] = Id(tag.Name)
-					}
-				}
-			}
+		tgt.Constraints = findConstraints(tgt)
+		if len(tgt.Constraints) > 0 && !cfg.Factory {
+			fmt.Fprintf(os.Stderr, "Type %q has option constraints, which are only checked by its factory, so -factory is needed\n", t)
+			os.Exit(1)
 		}
 
-		if *factory {
-			f.Add(
-				Func().Id("New"+t).Params(Id("opts").Op("...").Id(optionName)).Op("*").Id(t).Block(
-					Id("o").Op(":=").Op("&").Id(t).Values(DictFunc(setDefaults)),
-					Line(),
-					For(Id("_, opt").Op(":=").Range().Id("opts")).Block(
-						Id("opt").Call(Id("o")),
-					),
-					Line(),
-					Return(Id("o")),
-				),
-				Line(),
-			)
+		tgt.Envs = findEnvVars(tgt)
+		if len(tgt.Envs) > 0 && !cfg.Factory {
+			fmt.Fprintf(os.Stderr, "Type %q has fields read from the environment, which is only done by its factory, so -factory is needed\n", t)
+			os.Exit(1)
 		}
 
-		for _, field := range keys {
-			typeName := fields[field].Type
+		if cfg.JSON {
+			checkUnmarshalJSON(t)
+		}
 
-			titledField := field
-			if unicode.IsLower(firstRune(field)) {
-				if !*unexported {
-					continue
-				}
-				titledField = strings.Title(field)
-			}
+		targets[t] = tgt
+	}
 
-			f.Add(
-				Func().Id(*prefix+titledField).Params(Id("x").Add(typeName)).Id(optionName).Block(
-					Return(
-						Func().Params(Id("o").Op("*").Id(t)).Block(
-							Id("o").Dot(field).Op("=").Id("x"),
-						),
-					),
-				),
-				Line(),
-			)
+	// The types are generated in order, so types sharing an output file
+	// always come out the same way.
+	outputs := []*outputFile{}
+	byName := map[string]*outputFile{}
+
+	for _, t := range names {
+		tgt := targets[t]
+
+		outFile := inPkgDir(outputName(tgt))
+		out, ok := byName[outFile]
+		if !ok {
+			out = &outputFile{Name: outFile, File: NewFile(pkg.Name)}
+			outputs = append(outputs, out)
+			byName[outFile] = out
+		}
+
+		generateType(out.File, tgt)
+		out.Types = append(out.Types, t)
+	}
+
+	for _, out := range outputs {
+		// one comment per line, since jen makes a block comment out of
+		// anything longer, and the marker has to be a line comment
+		for _, line := range strings.Split(generatedHeader(out.Types), "\n") {
+			out.File.HeaderComment(line)
+		}
+	}
+
+	// Render everything up front, so nothing is written if something's
+	// wrong, and so -check can compare it all against what's on disk.
+	files := []*pendingFile{}
+
+	for _, out := range outputs {
+		buf := &bytes.Buffer{}
+		if err := out.File.Render(buf); err != nil {
+			panic(err)
 		}
 
-		outFile := fmt.Sprintf("zz_generated.%s_funcop.go", strings.ToLower(t))
+		file := &pendingFile{Name: out.Name, Content: buf.Bytes()}
+		for _, t := range out.Types {
+			file.Done = append(file.Done, fmt.Sprintf("Generated functional options for `%s.%s`", pkg.Name, t))
+		}
+		files = append(files, file)
+	}
 
-		if err := f.Save(outFile); err != nil {
+	if *docs != "" {
+		docsFile := inPkgDir(*docs)
+		b, err := ioutil.ReadFile(docsFile)
+		if err != nil && !os.IsNotExist(err) {
 			panic(err)
 		}
 
-		fmt.Printf("Generated functional options for `%s.%s`\n", pkg.Name, t)
+		file := &pendingFile{Name: docsFile}
+		for _, t := range names {
+			b = withDocs(b, targets[t], *docs)
+			file.Done = append(file.Done, fmt.Sprintf("Documented functional options for `%s.%s` in %s", pkg.Name, t, relPath(docsFile)))
+		}
+		file.Content = b
+		files = append(files, file)
+	}
+
+	for _, t := range names {
+		if targets[t].Config.Schema {
+			files = append(files, &pendingFile{
+				Name:    inPkgDir(fmt.Sprintf("%s.schema.json", strings.ToLower(t))),
+				Content: schemaJSON(targets[t]),
+				Done:    []string{fmt.Sprintf("Generated JSON Schema for `%s.%s`", pkg.Name, t)},
+			})
+		}
+	}
+
+	stale, untouched := staleFiles(files)
+
+	if *check {
+		return checkFiles(files, stale, untouched)
+	}
+
+	writeFiles(files)
+	pruneFiles(stale)
+	return true
+}
+
+// pkgDir returns the directory of the current package.
+func pkgDir() string {
+	return filepath.Dir(pkg.Fset.File(pkg.Syntax[0].Pos()).Name())
+}
+
+// inPkgDir returns where a file named relative to the current package goes.
+func inPkgDir(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(pkgDir(), name)
+}
+
+// generateType adds everything generated for the type to the file.
+func generateType(f *File, tgt *target) {
+	f.Add(Type().Id(tgt.Option).Func().Params(Op("*").Id(tgt.Name)), Line())
+
+	if len(tgt.Constraints) > 0 {
+		addTracker(f, tgt)
+	}
+
+	if tgt.Config.Factory {
+		f.Add(factoryFunc(tgt), Line())
+	}
+
+	if tgt.Config.Defaults {
+		for _, code := range defaultsFuncs(tgt) {
+			f.Add(code, Line())
+		}
+	}
+
+	if tgt.Config.JSON {
+		f.Add(unmarshalJSONFunc(tgt), Line())
+	}
+
+	if tgt.Config.Flags {
+		addSizedFlag(f, tgt)
+		f.Add(registerFlagsFunc(tgt), Line())
+	}
+
+	if tgt.Config.FromMap {
+		f.Add(optionsFromMapFunc(tgt), Line())
+	}
+
+	if tgt.Config.FromStruct {
+		f.Add(fromFunc(tgt), Line())
+		f.Add(toOptionsFunc(tgt), Line())
+	}
+
+	for _, field := range tgt.Keys {
+		typeName := tgt.Fields[field].Type
+
+		optionFunc, ok := tgt.optionFuncName(field)
+		if !ok {
+			if _, ok := findDeprecation(tgt.Fields[field]); ok {
+				fieldFatalf(tgt.Fields[field], "%s is deprecated, but it has no option", field)
+			}
+			continue
+		}
+
+		if notice, ok := findDeprecation(tgt.Fields[field]); ok {
+			f.Comment("Deprecated: " + notice)
+		}
+
+		f.Add(
+			Func().Id(optionFunc).Params(Id("x").Add(typeName)).Id(tgt.Option).Block(
+				Return(
+					Func().Params(Id("o").Op("*").Id(tgt.Name)).BlockFunc(func(g *Group) {
+						g.Id("o").Dot(field).Op("=").Id("x")
+						if tgt.tracked(field) {
+							g.Id(trackerVar(tgt)).Dot("mark").Call(Id("o"), Lit(field), True())
+						}
+					}),
+				),
+			),
+			Line(),
+		)
+
+		for _, alias := range findAliases(tgt, field) {
+			f.Add(aliasFunc(tgt, field, alias), Line())
+		}
+
+		if tgt.Config.Reset {
+			f.Add(resetFunc(tgt, field), Line())
+		}
+	}
+
+	if tgt.Validate != nil {
+		addValidatePatterns(f, tgt)
+		f.Add(tgt.Validate, Line())
 	}
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+
+	. "github.com/dave/jennifer/jen"
+)
+
+// outputFile is a file we're generating, along with the types it's for.
+// Types whose -output template renders to the same name share a file.
+type outputFile struct {
+	Name  string
+	File  *File
+	Types []string
+}
+
+// parseOutputTemplate parses an -output template, exiting if it's broken.
+func parseOutputTemplate(text string) *template.Template {
+	tmpl, err := template.New("output").Funcs(template.FuncMap{
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+	}).Option("missingkey=error").Parse(text)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bad -output template: %v\n", err)
+		os.Exit(1)
+	}
+
+	return tmpl
+}
+
+// outputName renders the name of the file the type's options go in.
+func outputName(t *target) string {
+	b := &strings.Builder{}
+
+	err := t.Config.outputTemplate.Execute(b, struct {
+		Type    string
+		Package string
+	}{t.Name, pkg.Name})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bad -output template: %v\n", err)
+		os.Exit(1)
+	}
+
+	if b.Len() == 0 {
+		fmt.Fprintf(os.Stderr, "-output template renders to an empty file name for %q\n", t.Name)
+		os.Exit(1)
+	}
+
+	return b.String()
+}
+
+// pendingFile is the content of a file we're about to write, along with what
+// to tell the user once it's written.
+type pendingFile struct {
+	Name    string
+	Content []byte
+	Done    []string
+}
+
+// writeFiles writes the files, exiting if any of them can't be.
+func writeFiles(files []*pendingFile) {
+	for _, file := range files {
+		if err := ioutil.WriteFile(file.Name, file.Content, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't write %s: %v\n", relPath(file.Name), err)
+			os.Exit(1)
+		}
+
+		for _, done := range file.Done {
+			fmt.Println(done)
+		}
+	}
+}
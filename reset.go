@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	. "github.com/dave/jennifer/jen"
+)
+
+// resetFunc generates an option restoring the field to its default, e.g.
+// ResetColor(), so a later option can undo an earlier one. Fields without a
+// default are reset to their zero value.
+func resetFunc(t *target, field string) *Statement {
+	titledField := field
+	if unicode.IsLower(firstRune(field)) {
+		titledField = strings.Title(field)
+	}
+
+	deprecation := Null()
+	if notice, ok := findDeprecation(t.Fields[field]); ok {
+		deprecation = Comment("Deprecated: " + notice).Line()
+	}
+
+	return deprecation.Func().Id("Reset" + titledField).Params().Id(t.Option).Block(
+		Return(
+			Func().Params(Id("o").Op("*").Id(t.Name)).BlockFunc(func(g *Group) {
+				if value, ok := t.defaultValue(field); ok {
+					g.Id("o").Dot(field).Op("=").Add(value)
+				} else {
+					g.Var().Id("zero").Add(t.Fields[field].Type)
+					g.Id("o").Dot(field).Op("=").Id("zero")
+				}
+				if t.tracked(field) {
+					g.Id(trackerVar(t)).Dot("mark").Call(Id("o"), Lit(field), False())
+				}
+			}),
+		),
+	)
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/structtag"
+)
+
+// schemaFor returns the JSON Schema of values of the given type as they'd be
+// encoded by encoding/json, or false if they can't be encoded at all, e.g.
+// funcs and chans. Structs are described all the way down, except for ones
+// we're already in the middle of describing.
+func schemaFor(typ types.Type, seen map[types.Type]bool) (map[string]interface{}, bool) {
+	switch {
+	case isDuration(typ):
+		return map[string]interface{}{"type": "integer", "description": "A duration in nanoseconds."}, true
+	case isNamed(typ, "time", "Time"):
+		return map[string]interface{}{"type": "string", "format": "date-time"}, true
+	}
+
+	switch t := typ.Underlying().(type) {
+	case *types.Basic:
+		info := t.Info()
+		switch {
+		case info&types.IsString != 0:
+			return map[string]interface{}{"type": "string"}, true
+		case info&types.IsBoolean != 0:
+			return map[string]interface{}{"type": "boolean"}, true
+		case info&types.IsUnsigned != 0:
+			return map[string]interface{}{"type": "integer", "minimum": 0}, true
+		case info&types.IsInteger != 0:
+			return map[string]interface{}{"type": "integer"}, true
+		case info&types.IsFloat != 0:
+			return map[string]interface{}{"type": "number"}, true
+		}
+	case *types.Pointer:
+		return schemaFor(t.Elem(), seen)
+	case *types.Slice:
+		if basic, ok := t.Elem().(*types.Basic); ok && basic.Kind() == types.Byte {
+			return map[string]interface{}{"type": "string", "contentEncoding": "base64"}, true
+		}
+		items, ok := schemaFor(t.Elem(), seen)
+		return map[string]interface{}{"type": "array", "items": items}, ok
+	case *types.Array:
+		items, ok := schemaFor(t.Elem(), seen)
+		return map[string]interface{}{"type": "array", "items": items, "minItems": t.Len(), "maxItems": t.Len()}, ok
+	case *types.Map:
+		values, ok := schemaFor(t.Elem(), seen)
+		return map[string]interface{}{"type": "object", "additionalProperties": values}, ok
+	case *types.Interface:
+		return map[string]interface{}{}, true
+	case *types.Struct:
+		if seen[typ] {
+			return map[string]interface{}{"type": "object"}, true
+		}
+		seen[typ] = true
+		defer delete(seen, typ)
+
+		props := map[string]interface{}{}
+		for _, f := range jsonFields(t) {
+			if prop, ok := fieldSchema(f, seen); ok {
+				props[f.name] = prop
+			}
+		}
+
+		return map[string]interface{}{"type": "object", "properties": props}, true
+	}
+
+	return nil, false
+}
+
+// isNamed returns whether the type is the named type from the given package.
+func isNamed(typ types.Type, path, name string) bool {
+	named, ok := types.Unalias(typ).(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == path && named.Obj().Name() == name
+}
+
+// jsonField is a field of a struct as encoding/json sees it.
+type jsonField struct {
+	name  string
+	field *types.Var
+	tags  *structtag.Tags
+
+	// top is the field of the struct itself the field is in, i.e. the
+	// embedded struct it's promoted from if it's promoted from one, and
+	// depth is how many embedded structs down it is
+	top    *types.Var
+	depth  int
+	tagged bool
+}
+
+// jsonFields returns the fields of the struct that encoding/json decodes into,
+// with the fields of embedded structs without a name in their json tag
+// promoted into it like encoding/json promotes them. When several fields have
+// the same name, the least deeply embedded one wins, then the one with a json
+// tag, and if that still doesn't settle it, none of them do.
+func jsonFields(s *types.Struct) []jsonField {
+	all := []jsonField{}
+
+	var collect func(s *types.Struct, top *types.Var, depth int, seen map[*types.Struct]bool)
+	collect = func(s *types.Struct, top *types.Var, depth int, seen map[*types.Struct]bool) {
+		seen[s] = true
+		defer delete(seen, s)
+
+		for i := 0; i < s.NumFields(); i++ {
+			field := s.Field(i)
+			if depth == 0 {
+				top = field
+			}
+
+			tags, err := structtag.Parse(s.Tag(i))
+			if err != nil {
+				fatalAt(field.Pos(), "bad tags for %s: %v", field.Name(), err)
+			}
+			name, ok := jsonName(field.Name(), tags)
+			if !ok {
+				continue
+			}
+			tag, _ := tags.Get("json")
+			tagged := tag != nil && tag.Name != ""
+
+			if field.Embedded() && !tagged {
+				typ := field.Type()
+				ptr, isPtr := typ.Underlying().(*types.Pointer)
+				if isPtr {
+					typ = ptr.Elem()
+				}
+				if embedded, ok := typ.Underlying().(*types.Struct); ok {
+					// encoding/json can't allocate unexported ones
+					if (field.Exported() || !isPtr) && !seen[embedded] {
+						collect(embedded, top, depth+1, seen)
+					}
+					continue
+				}
+			}
+
+			if field.Exported() {
+				all = append(all, jsonField{name, field, tags, top, depth, tagged})
+			}
+		}
+	}
+	collect(s, nil, 0, map[*types.Struct]bool{})
+
+	byName := map[string][]jsonField{}
+	for _, f := range all {
+		byName[f.name] = append(byName[f.name], f)
+	}
+
+	out := []jsonField{}
+	for _, f := range all {
+		if f.field == dominantField(byName[f.name]) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// dominantField returns the field encoding/json uses out of the ones with the
+// same name, or nil if there isn't one, see jsonFields.
+func dominantField(fields []jsonField) *types.Var {
+	depth := fields[0].depth
+	for _, f := range fields {
+		if f.depth < depth {
+			depth = f.depth
+		}
+	}
+
+	var shallowest, tagged []jsonField
+	for _, f := range fields {
+		if f.depth != depth {
+			continue
+		}
+		shallowest = append(shallowest, f)
+		if f.tagged {
+			tagged = append(tagged, f)
+		}
+	}
+
+	switch {
+	case len(shallowest) == 1:
+		return shallowest[0].field
+	case len(tagged) == 1:
+		return tagged[0].field
+	}
+	return nil
+}
+
+// fieldSchema returns the JSON Schema of the field with its default, or false
+// if it can't be encoded at all.
+func fieldSchema(f jsonField, seen map[types.Type]bool) (map[string]interface{}, bool) {
+	prop, ok := schemaFor(f.field.Type(), seen)
+	if !ok {
+		return nil, false
+	}
+	if tag, _ := f.tags.Get("default"); tag != nil {
+		if v, ok := schemaDefault(f.field.Type(), tag.Value()); ok {
+			prop["default"] = v
+		}
+	}
+	return prop, true
+}
+
+// jsonName returns the name a field is encoded under by encoding/json, or
+// false if it's skipped with `json:"-"`.
+func jsonName(field string, tags *structtag.Tags) (string, bool) {
+	tag, _ := tags.Get("json")
+	switch {
+	case tag == nil || tag.Name == "":
+		return field, true
+	case tag.Name == "-":
+		return "", false
+	}
+	return tag.Name, true
+}
+
+// schemaDefault turns a field's default into the value it'd be in JSON, or
+// false if it's something only known at runtime, like an expression.
+func schemaDefault(typ types.Type, value string) (interface{}, bool) {
+	if isDuration(typ) {
+		d, err := time.ParseDuration(value)
+		return int64(d), err == nil
+	}
+
+	if hasJSONDefault(typ) {
+		if !json.Valid([]byte(value)) {
+			return nil, false
+		}
+		return json.RawMessage(value), true
+	}
+
+	if ptr, ok := typ.Underlying().(*types.Pointer); ok {
+		return schemaDefault(ptr.Elem(), value)
+	}
+
+	basic, ok := typ.Underlying().(*types.Basic)
+	if !ok {
+		return nil, false
+	}
+	return schemaConst(basic, value)
+}
+
+// schemaConst parses the string as a constant of the basic type, returning it
+// as a JSON value, or false if it isn't one.
+func schemaConst(basic *types.Basic, s string) (interface{}, bool) {
+	lit, err := constLit(basic, s)
+	if err != nil {
+		return nil, false
+	}
+
+	switch {
+	case basic.Info()&types.IsString != 0:
+		return s, true
+	case basic.Info()&types.IsBoolean != 0:
+		v, _ := strconv.ParseBool(s)
+		return v, true
+	}
+
+	// constLit has already normalized the number, e.g. 0x10 into 16
+	return json.Number(fmt.Sprintf("%#v", lit)), true
+}
+
+// schemaDoc returns the JSON Schema describing the type's options, as they'd
+// be given in a JSON config file.
+func schemaDoc(t *target) map[string]interface{} {
+	props := map[string]interface{}{}
+	required := []string{}
+
+	// the fields of embedded structs are promoted like encoding/json
+	// promotes them, by the field of the type they're promoted from
+	fields := map[string][]jsonField{}
+	if s, ok := pkg.Types.Scope().Lookup(t.Name).Type().Underlying().(*types.Struct); ok {
+		for _, f := range jsonFields(s) {
+			fields[f.top.Name()] = append(fields[f.top.Name()], f)
+		}
+	}
+
+	for _, field := range t.Keys {
+		data := t.Fields[field]
+		if _, ok := t.optionFuncName(field); !ok || data.GoType == nil {
+			continue
+		}
+
+		var name string
+		for _, f := range fields[field] {
+			if f.depth == 0 {
+				name = f.name
+				continue
+			}
+			if prop, ok := fieldSchema(f, map[types.Type]bool{}); ok {
+				props[f.name] = prop
+			}
+		}
+		// unexported fields only have options with -unexported, but
+		// encoding/json never decodes them
+		if name == "" || !token.IsExported(field) {
+			continue
+		}
+
+		prop, ok := schemaFor(data.GoType, map[types.Type]bool{})
+		if !ok {
+			continue
+		}
+
+		if doc := fieldDoc(data); doc != "" {
+			prop["description"] = doc
+		}
+		if _, ok := findDeprecation(data); ok {
+			prop["deprecated"] = true
+		}
+
+		defaultTag, _ := data.Tags.Get("default")
+		if defaultTag != nil {
+			if v, ok := schemaDefault(data.GoType, defaultTag.Value()); ok {
+				prop["default"] = v
+			}
+		}
+
+		basic, _ := data.GoType.Underlying().(*types.Basic)
+		for _, rule := range findValidateRules(data) {
+			switch rule.Name {
+			case "min":
+				prop["minimum"], _ = schemaConst(basic, rule.Arg)
+			case "max":
+				prop["maximum"], _ = schemaConst(basic, rule.Arg)
+			case "oneof":
+				enum := []interface{}{}
+				for _, v := range strings.Fields(rule.Arg) {
+					c, _ := schemaConst(basic, v)
+					enum = append(enum, c)
+				}
+				prop["enum"] = enum
+			case "regexp":
+				prop["pattern"] = rule.Arg
+			case "nonempty":
+				switch prop["type"] {
+				case "string":
+					prop["minLength"] = 1
+				case "array":
+					prop["minItems"] = 1
+				case "object":
+					prop["minProperties"] = 1
+				}
+				// a field with a default can be left out of a config file
+				if defaultTag == nil {
+					required = append(required, name)
+				}
+			}
+		}
+
+		props[name] = prop
+	}
+
+	doc := map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"$comment":             generatedHeader([]string{t.Name}),
+		"title":                t.Name,
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	if desc := typeDoc(t.Name); desc != "" {
+		doc["description"] = desc
+	}
+	if len(required) > 0 {
+		doc["required"] = required
+	}
+
+	return doc
+}
+
+// fieldDoc returns the field's doc comment, or its trailing comment if it
+// doesn't have one, squashed onto one line.
+func fieldDoc(data *FieldData) string {
+	doc := ""
+	if data.Field.Doc != nil {
+		doc = data.Field.Doc.Text()
+	} else if data.Field.Comment != nil {
+		doc = data.Field.Comment.Text()
+	}
+	return strings.Join(strings.Fields(doc), " ")
+}
+
+// typeDoc returns the doc comment of the type declaration, squashed onto one
+// line.
+func typeDoc(name string) string {
+	for _, file := range pkg.Syntax {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				ts, ok := spec.(*ast.TypeSpec)
+				if !ok || ts.Name.Name != name {
+					continue
+				}
+				doc := ts.Doc
+				if doc == nil {
+					doc = gen.Doc
+				}
+				return strings.Join(strings.Fields(doc.Text()), " ")
+			}
+		}
+	}
+	return ""
+}
+
+// schemaJSON renders the type's JSON Schema.
+func schemaJSON(t *target) []byte {
+	b, err := json.MarshalIndent(schemaDoc(t), "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return append(b, '\n')
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"go/types"
+
+	. "github.com/dave/jennifer/jen"
+	"github.com/fatih/structtag"
+)
+
+// defaultsFuncs generates the standalone defaults API of the type, so its
+// defaults can be used without the factory, e.g. for structs decoded from a
+// config file:
+//
+//   - DefaultAnimal() returns an Animal with only its defaults set
+//   - (*Animal).SetDefaults() sets the defaults of any zero fields, leaving
+//     fields that are already set alone
+//   - AnimalDefaults() returns the defaults by field name, for inspecting them
+func defaultsFuncs(t *target) []Code {
+	defaultFunc := Func().Id("Default" + t.Name).Params().Id(t.Name).Block(
+		Return(Id(t.Name).Values(DictFunc(t.setDefaults))),
+	)
+
+	setDefaults := Func().Params(Id("o").Op("*").Id(t.Name)).Id("SetDefaults").Params().BlockFunc(func(g *Group) {
+		for _, field := range t.Keys {
+			data := t.Fields[field]
+			x := Id("o").Dot(field)
+
+			if tag, _ := data.Tags.Get("default"); tag != nil {
+				value, _ := t.defaultValue(field)
+				g.If(zeroCheck(data.GoType, x.Clone())).Block(
+					x.Clone().Op("=").Add(value),
+				)
+				continue
+			}
+
+			if data.GoType != nil {
+				_, init := findFuncopTag(data)["init"]
+				setNestedDefaults(g, data.GoType, init, x, map[types.Type]bool{})
+			}
+		}
+	})
+
+	defaults := Func().Id(t.Name + "Defaults").Params().Map(String()).Interface().BlockFunc(func(g *Group) {
+		d := Dict{}
+		for _, field := range t.Keys {
+			if _, ok := t.defaultValue(field); ok {
+				d[Lit(field)] = Id("d").Dot(field)
+			}
+		}
+
+		g.Id("d").Op(":=").Id("Default" + t.Name).Call()
+		g.Return(Map(String()).Interface().Values(d))
+	})
+
+	return []Code{defaultFunc, setDefaults, defaults}
+}
+
+// setNestedDefaults generates the setting of the defaults of x's own fields if
+// it's a struct, all the way down, checking each of them for zero values
+// rather than x as a whole. Pointers to structs that should be initialized
+// are, if they're nil. See nestedDefault.
+func setNestedDefaults(g *Group, typ types.Type, init bool, x *Statement, seen map[types.Type]bool) {
+	typ = types.Unalias(typ)
+	if _, ok := typ.(*types.Pointer); ok {
+		if value, ok := nestedDefault(typ, init, false, seen); ok {
+			g.If(x.Clone().Op("==").Nil()).Block(
+				x.Clone().Op("=").Add(value),
+			)
+		}
+		return
+	}
+
+	named, ok := typ.(*types.Named)
+	if !ok || seen[named] {
+		return
+	}
+	s, ok := named.Underlying().(*types.Struct)
+	if !ok {
+		return
+	}
+
+	seen[named] = true
+	defer delete(seen, named)
+
+	for i := 0; i < s.NumFields(); i++ {
+		field := s.Field(i)
+		if !field.Exported() && field.Pkg() != pkg.Types {
+			continue
+		}
+
+		tags, err := structtag.Parse(s.Tag(i))
+		if err != nil {
+			fatalAt(field.Pos(), "bad tags for %s: %v", field.Name(), err)
+		}
+
+		fx := x.Clone().Dot(field.Name())
+
+		if tag, _ := tags.Get("default"); tag != nil {
+			if code, ok := nestedFieldDefault(field, tag.Value()); ok {
+				g.If(zeroCheck(field.Type(), fx.Clone())).Block(
+					fx.Clone().Op("=").Add(code),
+				)
+			}
+			continue
+		}
+
+		setNestedDefaults(g, field.Type(), hasFuncopInit(tags), fx, seen)
+	}
+}
+
+// zeroCheck returns the condition checking if x is the zero value of its type.
+func zeroCheck(typ types.Type, x *Statement) *Statement {
+	return compareZero(typ, x, true)
+}
+
+// nonZeroCheck returns the condition checking if x isn't the zero value of its
+// type.
+func nonZeroCheck(typ types.Type, x *Statement) *Statement {
+	return compareZero(typ, x, false)
+}
+
+// compareZero returns the condition comparing x to the zero value of its type,
+// checking that it is if zero is set, and that it isn't otherwise.
+func compareZero(typ types.Type, x *Statement, zero bool) *Statement {
+	op, not := "!=", Op("!")
+	if zero {
+		op, not = "==", Null()
+	}
+
+	if typ == nil {
+		return not.Qual("reflect", "ValueOf").Call(x).Dot("IsZero").Call()
+	}
+
+	switch t := typ.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case t.Info()&types.IsString != 0:
+			return x.Op(op).Lit("")
+		case t.Info()&types.IsBoolean != 0:
+			if zero {
+				return Op("!").Add(x)
+			}
+			return x
+		case t.Info()&types.IsNumeric != 0:
+			return x.Op(op).Lit(0)
+		}
+	case *types.Pointer, *types.Slice, *types.Map, *types.Chan, *types.Signature, *types.Interface:
+		return x.Op(op).Nil()
+	}
+
+	if types.Comparable(typ) {
+		return x.Op(op).Parens(jenTypeOf(typ).Values())
+	}
+
+	return not.Qual("reflect", "ValueOf").Call(x).Dot("IsZero").Call()
+}
+
+// hasFuncopInit returns whether the tags have a `funcop:"init"`.
+func hasFuncopInit(tags *structtag.Tags) bool {
+	tag, _ := tags.Get("funcop")
+	if tag == nil {
+		return false
+	}
+
+	for _, opt := range append([]string{tag.Name}, tag.Options...) {
+		if opt == "init" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"go/types"
+
+	. "github.com/dave/jennifer/jen"
+)
+
+// checkUnmarshalJSON exits if the type already has an UnmarshalJSON method
+// we'd be clashing with, either its own or one promoted from an embedded
+// field. Methods we've generated ourselves don't count, since we're about to
+// regenerate them anyway.
+func checkUnmarshalJSON(t string) {
+	obj := pkg.Types.Scope().Lookup(t)
+	if obj == nil {
+		return
+	}
+
+	method, _, _ := types.LookupFieldOrMethod(types.NewPointer(obj.Type()), true, pkg.Types, "UnmarshalJSON")
+	if method == nil || isGenerated(fileOf(method.Pos())) {
+		return
+	}
+
+	fatalAt(obj.Pos(), "type %s already has an UnmarshalJSON method, so it can't be generated with -json", t)
+}
+
+// unmarshalJSONFunc generates an UnmarshalJSON method for the type that starts
+// from the same defaults as the factory, so a struct decoded from JSON ends up
+// the same as one built from options. The decoding is done into a type without
+// any methods, so it doesn't call itself.
+func unmarshalJSONFunc(t *target) *Statement {
+	return Func().Params(Id("o").Op("*").Id(t.Name)).Id("UnmarshalJSON").Params(Id("data").Index().Byte()).Error().Block(
+		Type().Id("plain").Id(t.Name),
+		Line(),
+		Id("v").Op(":=").Id("plain").Values(DictFunc(t.setDefaults)),
+		If(Err().Op(":=").Qual("encoding/json", "Unmarshal").Call(Id("data"), Op("&").Id("v")), Err().Op("!=").Nil()).Block(
+			Return(Err()),
+		),
+		Line(),
+		Op("*").Id("o").Op("=").Id(t.Name).Call(Id("v")),
+		Return(Nil()),
+	)
+}
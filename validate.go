@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"regexp"
+	"strconv"
+	"strings"
+
+	. "github.com/dave/jennifer/jen"
+)
+
+// validateRule is a single rule from a field's `validate` tag, e.g. min=1.
+type validateRule struct {
+	Name string
+	Arg  string
+}
+
+// findValidateRules parses a field's `validate` tag into its rules, checking
+// each of them against the field's type. A tag like
+// `validate:"min=1,max=65535"` has two rules. Since a regular expression may
+// have commas of its own, a regexp rule eats the rest of the tag.
+func findValidateRules(data *FieldData) []validateRule {
+	tag, _ := data.Tags.Get("validate")
+	if tag == nil {
+		return nil
+	}
+
+	rules := []validateRule{}
+
+	for rest := tag.Value(); rest != ""; {
+		var part string
+		if strings.HasPrefix(rest, "regexp=") {
+			part, rest = rest, ""
+		} else if i := strings.Index(rest, ","); i >= 0 {
+			part, rest = rest[:i], rest[i+1:]
+		} else {
+			part, rest = rest, ""
+		}
+
+		split := strings.SplitN(part, "=", 2)
+		rule := validateRule{Name: split[0]}
+		if len(split) == 2 {
+			rule.Arg = split[1]
+		}
+
+		checkValidateRule(data, rule)
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+// checkValidateRule exits if the rule is unknown, malformed, or doesn't make
+// sense for the field's type, e.g. min on a string.
+func checkValidateRule(data *FieldData, rule validateRule) {
+	var basic *types.Basic
+	var underlying types.Type
+	if data.GoType != nil {
+		underlying = data.GoType.Underlying()
+		basic, _ = underlying.(*types.Basic)
+	}
+
+	switch rule.Name {
+	case "min", "max":
+		if basic == nil || basic.Info()&types.IsNumeric == 0 || basic.Info()&types.IsComplex != 0 {
+			fieldFatalf(data, "validate rule %q needs a number, but the field is a %s", rule.Name, data.GoType)
+		}
+		if _, err := constLit(basic, rule.Arg); err != nil {
+			fieldFatalf(data, "validate rule %q: %v", rule.Name, err)
+		}
+	case "oneof":
+		if basic == nil || basic.Info()&(types.IsString|types.IsInteger|types.IsFloat) == 0 {
+			fieldFatalf(data, "validate rule %q needs a string or a number, but the field is a %s", rule.Name, data.GoType)
+		}
+		if len(strings.Fields(rule.Arg)) == 0 {
+			fieldFatalf(data, "validate rule %q needs at least one value", rule.Name)
+		}
+		for _, v := range strings.Fields(rule.Arg) {
+			if _, err := constLit(basic, v); err != nil {
+				fieldFatalf(data, "validate rule %q: %v", rule.Name, err)
+			}
+		}
+	case "nonempty":
+		if rule.Arg != "" {
+			fieldFatalf(data, "validate rule %q doesn't take a value", rule.Name)
+		}
+		if _, ok := emptyCheck(underlying, Id("x")); !ok {
+			fieldFatalf(data, "validate rule %q can't be used with a %s", rule.Name, data.GoType)
+		}
+	case "regexp":
+		if basic == nil || basic.Info()&types.IsString == 0 {
+			fieldFatalf(data, "validate rule %q needs a string, but the field is a %s", rule.Name, data.GoType)
+		}
+		if _, err := regexp.Compile(rule.Arg); err != nil {
+			fieldFatalf(data, "validate rule %q: %v", rule.Name, err)
+		}
+	default:
+		fieldFatalf(data, "unknown validate rule %q", rule.Name)
+	}
+}
+
+// constLit parses s as a constant of the given basic type, returning it as an
+// untyped literal so it can be compared against named types too.
+func constLit(basic *types.Basic, s string) (*Statement, error) {
+	info := basic.Info()
+
+	switch {
+	case info&types.IsString != 0:
+		return Lit(s), nil
+	case info&types.IsBoolean != 0:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't a valid %s", s, basic)
+		}
+		return Lit(v), nil
+	case info&types.IsUnsigned != 0:
+		v, err := strconv.ParseUint(s, 0, basicBits(basic))
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't a valid %s", s, basic)
+		}
+		return Op(strconv.FormatUint(v, 10)), nil
+	case info&types.IsInteger != 0:
+		v, err := strconv.ParseInt(s, 0, basicBits(basic))
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't a valid %s", s, basic)
+		}
+		return Op(strconv.FormatInt(v, 10)), nil
+	case info&types.IsFloat != 0:
+		v, err := strconv.ParseFloat(s, basicBits(basic))
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't a valid %s", s, basic)
+		}
+		return Op(strconv.FormatFloat(v, 'g', -1, basicBits(basic))), nil
+	}
+
+	return nil, fmt.Errorf("can't make a constant of type %s", basic)
+}
+
+// basicBits is the size in bits of a numeric basic type, taking int, uint and
+// uintptr to be 64 bits.
+func basicBits(basic *types.Basic) int {
+	switch basic.Kind() {
+	case types.Int8, types.Uint8:
+		return 8
+	case types.Int16, types.Uint16:
+		return 16
+	case types.Int32, types.Uint32, types.Float32:
+		return 32
+	}
+	return 64
+}
+
+// emptyCheck returns the condition checking if x is empty, i.e. has no length
+// or is nil, or false if there's no such thing for the type.
+func emptyCheck(underlying types.Type, x *Statement) (*Statement, bool) {
+	switch typ := underlying.(type) {
+	case *types.Basic:
+		if typ.Info()&types.IsString != 0 {
+			return Len(x).Op("==").Lit(0), true
+		}
+	case *types.Slice, *types.Map:
+		return Len(x).Op("==").Lit(0), true
+	case *types.Pointer, *types.Interface, *types.Chan, *types.Signature:
+		return x.Op("==").Nil(), true
+	}
+	return nil, false
+}
+
+// patternVar is the name of the variable holding the compiled regexp rule of
+// the field, so it's compiled once rather than on every call to Validate.
+func patternVar(t *target, field string) string {
+	return lowerFirst(t.Name) + field + "Pattern"
+}
+
+// addValidatePatterns adds the variables holding the compiled regexp rules of
+// the type's fields, if it has any.
+func addValidatePatterns(f *File, t *target) {
+	for _, field := range t.Keys {
+		for _, rule := range findValidateRules(t.Fields[field]) {
+			if rule.Name == "regexp" {
+				f.Var().Id(patternVar(t, field)).Op("=").Qual("regexp", "MustCompile").Call(Lit(rule.Arg))
+				f.Line()
+			}
+		}
+	}
+}
+
+// validateFunc generates a Validate method for the type checking its fields
+// against their validation rules, aggregating every failure into one error.
+// It returns nil if none of the fields have any rules.
+func validateFunc(t *target) *Statement {
+	checks := []Code{}
+
+	for _, field := range t.Keys {
+		data := t.Fields[field]
+		rules := findValidateRules(data)
+		if len(rules) == 0 {
+			continue
+		}
+
+		// name the option too so it's obvious where a bad value came from
+		name := field
+		if optionFunc, ok := t.optionFuncName(field); ok {
+			name = fmt.Sprintf("%s (%s)", field, optionFunc)
+		}
+
+		x := func() *Statement { return Id("o").Dot(field) }
+		basic, _ := data.GoType.Underlying().(*types.Basic)
+
+		verb := "%v"
+		if basic != nil && basic.Info()&types.IsString != 0 {
+			verb = "%q"
+		}
+
+		fail := func(format string, args ...Code) *Statement {
+			msg := Lit(name + ": " + format)
+			if len(args) > 0 {
+				msg = Qual("fmt", "Sprintf").Call(append([]Code{msg}, args...)...)
+			}
+			return Id("errs").Op("=").Append(Id("errs"), msg)
+		}
+
+		for _, rule := range rules {
+			switch rule.Name {
+			case "min":
+				min, _ := constLit(basic, rule.Arg)
+				checks = append(checks, If(x().Op("<").Add(min)).Block(
+					fail(verb+" is less than the minimum of %s", x(), Lit(rule.Arg)),
+				))
+			case "max":
+				max, _ := constLit(basic, rule.Arg)
+				checks = append(checks, If(x().Op(">").Add(max)).Block(
+					fail(verb+" is greater than the maximum of %s", x(), Lit(rule.Arg)),
+				))
+			case "oneof":
+				values := []Code{}
+				for _, v := range strings.Fields(rule.Arg) {
+					lit, _ := constLit(basic, v)
+					values = append(values, lit)
+				}
+				checks = append(checks, Switch(x()).Block(
+					Case(values...),
+					Default().Block(
+						fail(verb+" is not one of %s", x(), Lit(rule.Arg)),
+					),
+				))
+			case "nonempty":
+				empty, _ := emptyCheck(data.GoType.Underlying(), x())
+				checks = append(checks, If(empty).Block(
+					fail("must not be empty"),
+				))
+			case "regexp":
+				str := x()
+				if !types.Identical(data.GoType, types.Typ[types.String]) {
+					str = String().Call(x())
+				}
+				match := Id(patternVar(t, field)).Dot("MatchString").Call(str)
+				checks = append(checks, If(Op("!").Add(match)).Block(
+					fail("%q does not match %q", x(), Lit(rule.Arg)),
+				))
+			}
+		}
+	}
+
+	if len(checks) == 0 {
+		return nil
+	}
+
+	return Func().Params(Id("o").Op("*").Id(t.Name)).Id("Validate").Params().Error().BlockFunc(func(g *Group) {
+		g.Var().Id("errs").Index().String()
+		g.Line()
+		for _, check := range checks {
+			g.Add(check)
+		}
+		g.Line()
+		g.If(Len(Id("errs")).Op(">").Lit(0)).Block(
+			Return(Qual("fmt", "Errorf").Call(Lit("invalid "+t.Name+": %s"), Qual("strings", "Join").Call(Id("errs"), Lit("; ")))),
+		)
+		g.Return(Nil())
+	})
+}